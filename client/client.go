@@ -5,17 +5,34 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // Client provides a client object to connect to server via websocket
 type Client struct {
-	WS   *websocket.Conn
-	Data chan []byte
+	ID     int // ID is assigned by the hub when the client connects
+	WS     *websocket.Conn
+	Data   chan Frame  // Data queues the messages the hub sends to the client
+	Header http.Header // Header keeps the HTTP headers of the websocket handshake
+
+	ConnectedAt time.Time // ConnectedAt is when the client connected to the hub
+	JSON        bool      // JSON is set once the client sends a JSON message, the hub then replies with JSON frames
+	Nick        string    // Nick is the nickname the client registered, if any
+	LastSender  int       // LastSender is the id of the last client that sent a message to this client, 0 if none
+
+	Authenticated bool // Authenticated is set when the client id was given by the hub authenticator rather than assigned
+}
+
+// Frame is a websocket message queued for a client
+type Frame struct {
+	Type int // Type is websocket.TextMessage, websocket.BinaryMessage, or websocket.CloseMessage to close the connection
+	Data []byte
 }
 
 // InitClient provides a client that connects via websockets with the server hosted on the given address and path /ws
@@ -0,0 +1,273 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultRequestTimeout = 5 * time.Second // defaultRequestTimeout is how long a request waits for the hub to answer
+	defaultMessageBuffer  = 256             // defaultMessageBuffer is the number of inbound messages queued before reading stops
+)
+
+// ErrClosed is returned by requests on a connection that was closed
+var ErrClosed = errors.New("connection closed")
+
+// Conn is a connection to the hub speaking its JSON protocol. Requests wait for the answer of the hub,
+// and are sent one at a time, while the messages relayed to the connection are received on Messages
+type Conn struct {
+	ws      *websocket.Conn
+	dialer  *websocket.Dialer
+	header  http.Header
+	timeout time.Duration
+
+	requestMu sync.Mutex    // requestMu makes sure a single request waits for a reply at a time
+	lastAckID int           // lastAckID is the correlation id of the last relay, guarded by requestMu
+	replies   chan reply    // replies hands the answers of the hub to the pending request
+	messages  chan Message  // messages queues the messages relayed to the connection
+	done      chan struct{} // done is closed once the connection stops reading
+}
+
+// Option configures a connection made with Dial
+type Option func(*Conn)
+
+// WithToken authenticates the connection with a bearer token, for hubs started with an authenticator
+func WithToken(token string) Option {
+	return func(c *Conn) {
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithDialer sets the dialer used to connect to the hub, defaults to websocket.DefaultDialer
+func WithDialer(dialer *websocket.Dialer) Option {
+	return func(c *Conn) {
+		c.dialer = dialer
+	}
+}
+
+// WithTimeout sets how long requests wait for the hub to answer, defaults to 5 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Conn) {
+		c.timeout = timeout
+	}
+}
+
+// Message is a message relayed to the connection
+type Message struct {
+	ID   int       // ID is assigned by the hub and increases with every relayed message
+	From int       // From is the id of the sender, 0 for messages sent in binary frames, which only hold the body
+	Nick string    // Nick is the nickname of the sender when it has one
+	Room string    // Room is set for messages sent to a room
+	Seq  int       // Seq orders the messages the hub delivers
+	Sent time.Time // Sent is when the hub received the message
+	Body []byte
+}
+
+// Error is an error the hub answered a request with
+type Error struct {
+	Code   string `json:"code"` // Code is one of the error codes of the hub, such as user_not_found
+	Detail string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Detail
+}
+
+// command is a JSON message sent to the hub
+type command struct {
+	Type  string `json:"type"`
+	Users []int  `json:"users,omitempty"`
+	Body  string `json:"body,omitempty"`
+	ID    string `json:"id,omitempty"`
+}
+
+// messageFrame is a JSON message relayed by the hub
+type messageFrame struct {
+	ID   int    `json:"id"`
+	From int    `json:"from"`
+	Nick string `json:"nick"`
+	Room string `json:"room"`
+	Seq  int    `json:"seq"`
+	TS   int64  `json:"ts"` // TS is when the hub received the message, in unix milliseconds
+	Body string `json:"body"`
+}
+
+// reply is a frame answering a request
+type reply struct {
+	Type string
+	Data []byte
+}
+
+// Dial connects to the hub at url, such as ws://localhost:8080/ws, and switches the connection to JSON messages
+func Dial(url string, opts ...Option) (*Conn, error) {
+	c := &Conn{
+		dialer:   websocket.DefaultDialer,
+		header:   http.Header{},
+		timeout:  defaultRequestTimeout,
+		replies:  make(chan reply, 1),
+		messages: make(chan Message, defaultMessageBuffer),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	ws, _, err := c.dialer.Dial(url, c.header)
+	if err != nil {
+		return nil, err
+	}
+	c.ws = ws
+	go c.read()
+
+	// the hub answers in JSON once the connection sent a JSON message
+	if _, err := c.ID(); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ID asks the hub for the user id of the connection
+func (c *Conn) ID() (int, error) {
+	data, err := c.request(command{Type: "id"}, "id")
+	if err != nil {
+		return 0, err
+	}
+	var frame struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return 0, err
+	}
+	return frame.ID, nil
+}
+
+// List asks the hub for the ids of the other connected users
+func (c *Conn) List() ([]int, error) {
+	data, err := c.request(command{Type: "list"}, "list")
+	if err != nil {
+		return nil, err
+	}
+	var frame struct {
+		Users []int `json:"users"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, err
+	}
+	return frame.Users, nil
+}
+
+// Relay sends body to the users with the given ids, and waits until the hub handled it. It fails when the hub
+// couldn't deliver the message to some of the users, messages queued for offline users count as delivered
+func (c *Conn) Relay(ids []int, body []byte) error {
+	data, err := c.request(command{Type: "relay", Users: ids, Body: string(body)}, "ack")
+	if err != nil {
+		return err
+	}
+	var frame struct {
+		Failed []int `json:"failed"`
+	}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return err
+	}
+	if len(frame.Failed) > 0 {
+		return fmt.Errorf("message not delivered to users %v", frame.Failed)
+	}
+	return nil
+}
+
+// Messages receives the messages relayed to the connection, it is closed when the connection is.
+// The connection stops reading, answers to requests included, while the messages aren't received
+func (c *Conn) Messages() <-chan Message {
+	return c.messages
+}
+
+// Close sends a close frame to the hub and closes the connection
+func (c *Conn) Close() error {
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return c.ws.Close()
+}
+
+// request sends cmd to the hub and waits for a reply of the expected type, or for an error frame
+func (c *Conn) request(cmd command, expected string) ([]byte, error) {
+	c.requestMu.Lock()
+	defer c.requestMu.Unlock()
+	if cmd.Type == "relay" {
+		c.lastAckID++
+		cmd.ID = strconv.Itoa(c.lastAckID)
+	}
+
+	// drop the answer to a request that timed out, so that it isn't taken for the answer to this one
+	select {
+	case <-c.replies:
+	default:
+	}
+	if err := c.ws.WriteJSON(cmd); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-c.replies:
+		switch r.Type {
+		case expected:
+			return r.Data, nil
+		case "error":
+			hubErr := &Error{}
+			if err := json.Unmarshal(r.Data, hubErr); err != nil {
+				return nil, err
+			}
+			return nil, hubErr
+		default:
+			return nil, fmt.Errorf("unexpected %s reply to %s", r.Type, cmd.Type)
+		}
+	case <-c.done:
+		return nil, ErrClosed
+	case <-time.After(c.timeout):
+		return nil, fmt.Errorf("no reply to %s from the hub", cmd.Type)
+	}
+}
+
+// read dispatches the frames sent by the hub, relayed messages to Messages and anything else to the pending request
+func (c *Conn) read() {
+	defer close(c.messages)
+	defer close(c.done)
+	for {
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.ws.Close()
+			return
+		}
+		if messageType == websocket.BinaryMessage {
+			c.messages <- Message{Body: data}
+			continue
+		}
+
+		var head struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(data, &head) != nil {
+			continue // frames sent before the hub switched the connection to JSON
+		}
+		switch head.Type {
+		case "message":
+			var frame messageFrame
+			if json.Unmarshal(data, &frame) != nil {
+				continue
+			}
+			c.messages <- Message{ID: frame.ID, From: frame.From, Nick: frame.Nick, Room: frame.Room, Seq: frame.Seq,
+				Sent: time.Unix(0, frame.TS*int64(time.Millisecond)), Body: []byte(frame.Body)}
+		case "id", "list", "ack", "error":
+			select {
+			case c.replies <- reply{Type: head.Type, Data: data}:
+			default: // nobody is waiting for it
+			}
+		}
+	}
+}
@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
+	github.com/prometheus/client_golang v1.11.1
 )
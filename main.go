@@ -14,7 +14,10 @@ func main() {
 	} else {
 		switch os.Args[1] {
 		case "hub":
-			hub.InitHub(os.Args[2])
+			if err := hub.InitHub(os.Args[2]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
 		case "client":
 			client.InitClient(os.Args[2])
 		default:
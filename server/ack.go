@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// ackFrame tells the sender of a relay with a correlation id which users the message was delivered to
+type ackFrame struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Delivered []int  `json:"delivered"`
+	Failed    []int  `json:"failed"`           // Failed are users that weren't found or couldn't keep up with their messages
+	Queued    []int  `json:"queued,omitempty"` // Queued are offline users the message was queued for
+}
+
+func newAckFrame(id string) *ackFrame {
+	return &ackFrame{Type: "ack", ID: id, Delivered: []int{}, Failed: []int{}}
+}
+
+// record adds the outcome of the delivery to userID
+func (ack *ackFrame) record(userID int, delivered bool) {
+	if delivered {
+		ack.Delivered = append(ack.Delivered, userID)
+	} else {
+		ack.Failed = append(ack.Failed, userID)
+	}
+}
+
+// sendAck sends the ack in the client protocol, e.g. ack: id=abc delivered=2;3 failed=5
+func (hub *Hub) sendAck(c *client.Client, ack *ackFrame) bool {
+	if c.JSON {
+		return hub.sendJSON(c, ack)
+	}
+	text := fmt.Sprintf("ack: id=%s delivered=%s failed=%s", ack.ID, joinIDs(ack.Delivered), joinIDs(ack.Failed))
+	if len(ack.Queued) > 0 {
+		text += " queued=" + joinIDs(ack.Queued)
+	}
+	return hub.sendText(c, []byte(text))
+}
+
+// joinIDs lists user ids the way relay users are listed, e.g. 2;3
+func joinIDs(ids []int) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = fmt.Sprint(id)
+	}
+	return strings.Join(values, ";")
+}
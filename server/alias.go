@@ -0,0 +1,28 @@
+package server
+
+import "fmt"
+
+// expandAlias returns the commands msgStr expands to, following aliases nested in other aliases.
+// Commands that aren't aliases expand to themselves; expanding tracks the aliases being expanded to detect recursion
+func (hub *Hub) expandAlias(msgStr string, expanding map[string]bool) ([]string, error) {
+	aliased, found := hub.aliases[msgStr]
+	if !found {
+		return []string{msgStr}, nil
+	}
+	if expanding[msgStr] {
+		return nil, fmt.Errorf("recursive alias: %s", msgStr)
+	}
+
+	expanding[msgStr] = true
+	defer delete(expanding, msgStr)
+
+	commands := make([]string, 0, len(aliased))
+	for _, command := range aliased {
+		expanded, err := hub.expandAlias(command, expanding)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, expanded...)
+	}
+	return commands, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// CommandAuditRecord describes a command handled by the hub
+type CommandAuditRecord struct {
+	Command       string // Command is the name of the command, "unknown" when it wasn't recognized
+	ClientID      int    // ClientID is the user id of the client that sent the command
+	Authenticated bool   // Authenticated is set when ClientID is the user the authenticator identified, not an assigned id
+	Nick          string // Nick is the nickname of the client once the command ran, empty when it has none
+	Outcome       string // Outcome is "ok" or the error reported back to the client
+	RemoteIP      string // RemoteIP is the address the client connected from
+}
+
+// CommandAuditSink receives an audit trail of the control commands sent by clients
+type CommandAuditSink interface {
+	RecordCommand(record CommandAuditRecord)
+}
+
+// noopCommandAuditSink is the default sink, it discards every record
+type noopCommandAuditSink struct{}
+
+func (noopCommandAuditSink) RecordCommand(CommandAuditRecord) {}
+
+// auditRecord describes the command sent by c along with the identity and address of the client
+func auditRecord(c *client.Client, command, outcome string) CommandAuditRecord {
+	return CommandAuditRecord{Command: command, ClientID: c.ID, Authenticated: c.Authenticated, Nick: c.Nick, Outcome: outcome, RemoteIP: remoteIP(c)}
+}
+
+// remoteIP returns the address the client connected from, without the port
+func remoteIP(c *client.Client) string {
+	host, _, err := net.SplitHostPort(c.WS.RemoteAddr().String())
+	if err != nil {
+		return c.WS.RemoteAddr().String()
+	}
+	return host
+}
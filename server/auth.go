@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// MaxAuthenticatedUserID is the largest user id an Authenticator may return. Hubs started with an authenticator
+// assign ids above it, so that a client the hub assigns an id to never takes the id of an authenticated user
+const MaxAuthenticatedUserID = 1<<30 - 1
+
+// Authenticator validates the token a client sends on the websocket handshake
+type Authenticator interface {
+	// Authenticate returns the user id of the client holding token, between 1 and MaxAuthenticatedUserID,
+	// or 0 to let the hub assign one. An error rejects the handshake
+	Authenticate(token string) (userID int, err error)
+}
+
+// noopAuthenticator is the default authenticator, it accepts every client and lets the hub assign ids
+type noopAuthenticator struct{}
+
+func (noopAuthenticator) Authenticate(string) (int, error) { return 0, nil }
+
+var (
+	errShuttingDown      = errors.New("server shutting down")
+	errAlreadyConnected  = errors.New("user already connected")
+	errInvalidAuthUserID = errors.New("invalid authenticated user id")
+	errServerFull        = errors.New("server full")
+)
+
+// handshakeToken returns the bearer token of the Authorization header, or the token query
+// parameter for browser clients that can't set headers on the handshake
+func handshakeToken(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// parseBroadcastString parses a broadcast|body=con command and broadcasts its body
+func (hub *Hub) parseBroadcastString(message *HubMessage, msgStr string) error {
+	body, err := parseBroadcastBody(msgStr)
+	if err != nil {
+		return err
+	}
+	return hub.broadcast(message, body)
+}
+
+func parseBroadcastBody(msgStr string) (string, error) {
+	broadcast := strings.TrimPrefix(msgStr, "broadcast|")
+	if !strings.HasPrefix(broadcast, "body=") {
+		return "", errors.New("broadcast message should contain a body field")
+	}
+	return strings.TrimPrefix(broadcast, "body="), nil
+}
+
+// broadcast delivers body to every connected client but the one that sent message,
+// and tells the sender how many clients it reached
+func (hub *Hub) broadcast(message *HubMessage, body string) error {
+	if err := hub.checkRelayBody(body); err != nil {
+		return err
+	}
+
+	senderID := message.client.ID
+	payload, delivered := hub.intercept(senderID, []byte(body))
+	if !delivered {
+		return newCommandError(codeMessageDropped, "message dropped by the hub")
+	}
+
+	destClients := hub.getAllUsersExcept(senderID)
+	if hub.byteQuotaExceeded(message.client, len(payload)*len(destClients)) {
+		return newCommandError(codeQuotaExceeded, "byte quota exceeded")
+	}
+
+	relayed := hub.newRelayedMessage(senderID, payload)
+	relayed.binary = message.binary()
+	recipients := 0
+	for _, destClient := range destClients {
+		if hub.deliver(destClient, relayed) {
+			hub.countBytesSent(message.client, len(payload))
+			recipients++
+		}
+	}
+	hub.sendText(message.client, []byte(fmt.Sprintf("broadcast delivered to %d clients", recipients)))
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// Clients returns the ids of the connected clients, sorted. It is safe to call from any goroutine
+func (hub *Hub) Clients() []int {
+	hub.clientsMu.RLock()
+	ids := make([]int, 0, len(hub.clients))
+	for id := range hub.clients {
+		ids = append(ids, id)
+	}
+	hub.clientsMu.RUnlock()
+	sort.Ints(ids)
+	return ids
+}
+
+// lookupClient returns the connected client with the given id
+func (hub *Hub) lookupClient(id int) (*client.Client, bool) {
+	hub.clientsMu.RLock()
+	defer hub.clientsMu.RUnlock()
+	c, found := hub.clients[id]
+	return c, found
+}
+
+// clientsCount returns the number of connected clients
+func (hub *Hub) clientsCount() int {
+	hub.clientsMu.RLock()
+	defer hub.clientsMu.RUnlock()
+	return len(hub.clients)
+}
+
+// storeClient adds a connected client to the clients map
+func (hub *Hub) storeClient(c *client.Client) {
+	hub.clientsMu.Lock()
+	defer hub.clientsMu.Unlock()
+	hub.clients[c.ID] = c
+	hub.collectors.connectedClients.Set(float64(len(hub.clients)))
+}
+
+// removeClient deletes a client from the clients map
+func (hub *Hub) removeClient(id int) {
+	hub.clientsMu.Lock()
+	defer hub.clientsMu.Unlock()
+	delete(hub.clients, id)
+	hub.collectors.connectedClients.Set(float64(len(hub.clients)))
+}
+
+// clientsResult is the response of the clients endpoint
+type clientsResult struct {
+	Clients []int `json:"clients"`
+}
+
+// getClients lists the ids of the connected clients, it requires the bearer token when the hub has one
+func (hub *Hub) getClients(w http.ResponseWriter, r *http.Request) {
+	if hub.httpToken != "" && !hub.authorizedHTTP(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clientsResult{Clients: hub.Clients()})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// Reasons sent to clients the hub disconnects
+const (
+	reasonRateLimited     = "rate_limited"
+	reasonUnknownCommands = "too_many_unknown_commands"
+	reasonShuttingDown    = "shutting_down"
+	reasonServerFull      = "server_full"
+	reasonIdleTimeout     = "idle_timeout"
+	reasonReplaced        = "replaced" // reasonReplaced is sent when the user connected again with another connection
+	reasonRejected        = "rejected" // reasonRejected is sent when the hub refuses to register the client
+)
+
+// closingFrame tells a client why the hub is about to close its connection and whether it may reconnect
+type closingFrame struct {
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Reconnect bool   `json:"reconnect"`
+}
+
+// closingMessage is the closing frame telling the client why it is disconnected
+func closingMessage(c *client.Client, reason string, reconnect bool) []byte {
+	if c.JSON {
+		frame, _ := json.Marshal(closingFrame{Type: "closing", Reason: reason, Reconnect: reconnect})
+		return frame
+	}
+	return []byte(fmt.Sprintf("server: closing: reason=%s reconnect=%t", reason, reconnect))
+}
+
+// closeClient queues a closing frame explaining why the client is disconnected, followed by a close frame with
+// the given code and text, after which the write goroutine closes the connection. A client whose outbound buffer
+// is full is closed right away without them. The hub goroutine never writes to clients itself, so that a client
+// that stopped reading can't stall it
+func (hub *Hub) closeClient(c *client.Client, code int, text, reason string, reconnect bool) {
+	if hub.send(c, closingMessage(c, reason, reconnect)) {
+		hub.sendFrame(c, client.Frame{Type: websocket.CloseMessage, Data: websocket.FormatCloseMessage(code, text)})
+	}
+}
+
+// refuseClient writes the closing and close frames to a client the hub didn't register and closes its connection.
+// No write goroutine runs for such a client, so the frames are written right away
+func (hub *Hub) refuseClient(c *client.Client, code int, text, reason string, reconnect bool) {
+	deadline := time.Now().Add(writeWait)
+	c.WS.SetWriteDeadline(deadline)
+	c.WS.WriteMessage(websocket.TextMessage, closingMessage(c, reason, reconnect))
+	c.WS.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+	c.WS.Close()
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// sensitiveHeaders are never echoed back by the headers command
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Sec-Websocket-Key":   true,
+}
+
+// headersToBytes lists the handshake headers sorted by name, leaving out the sensitive ones
+func headersToBytes(header http.Header) []byte {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if !sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	value := []byte("headers: \n")
+	for _, name := range names {
+		for _, v := range header[name] {
+			value = append(value, []byte(fmt.Sprintf("%s: %s\n", name, v))...)
+		}
+	}
+	return value
+}
+
+// parsedCommand describes the fields the hub extracted from a command
+type parsedCommand struct {
+	Command string   `json:"command"`
+	Users   []string `json:"users,omitempty"`
+	Body    string   `json:"body,omitempty"`
+	DryRun  bool     `json:"dryrun,omitempty"`
+	ID      string   `json:"id,omitempty"`
+	Offset  int      `json:"offset,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+	Sort    string   `json:"sort,omitempty"`
+	Feed    string   `json:"feed,omitempty"`
+	Count   int      `json:"count,omitempty"`
+	Room    string   `json:"room,omitempty"`
+	Name    string   `json:"name,omitempty"`
+}
+
+// parseCommand parses msgStr the way the hub would in a text or binary frame, without executing it, and returns the extracted fields as JSON
+func parseCommand(msgStr string, binary bool) ([]byte, error) {
+	var parsed parsedCommand
+	switch {
+	case strings.HasPrefix(msgStr, "{"):
+		command, err := parseMessage([]byte(msgStr))
+		if err != nil {
+			return nil, err
+		}
+		for _, userID := range command.Users {
+			parsed.Users = append(parsed.Users, fmt.Sprint(userID))
+		}
+		parsed.Command, parsed.Body, parsed.Count, parsed.Room, parsed.ID = command.Type, command.Body, command.Count, command.Room, command.ID
+	case msgStr == "id", msgStr == "list", msgStr == "info", msgStr == "headers":
+		parsed.Command = msgStr
+	case strings.HasPrefix(msgStr, "list|"):
+		page, err := parseListPage(strings.TrimPrefix(msgStr, "list|"))
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "list", Offset: page.offset, Limit: page.limit, Sort: page.sort}
+	case strings.HasPrefix(msgStr, "relay"):
+		fields, err := parseRelayFields(msgStr, binary)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "relay", Users: fields.users, Body: fields.body, DryRun: fields.dryRun, ID: fields.ackID}
+	case strings.HasPrefix(msgStr, "broadcast|"):
+		body, err := parseBroadcastBody(msgStr)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "broadcast", Body: body}
+	case strings.HasPrefix(msgStr, "reply|"):
+		body, err := parseReplyBody(msgStr)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "reply", Body: body}
+	case strings.HasPrefix(msgStr, "history|"):
+		count, err := parseHistoryCount(msgStr)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "history", Count: count}
+	case strings.HasPrefix(msgStr, "nick|"):
+		nick, err := parseNick(msgStr)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "nick", Name: nick}
+	case strings.HasPrefix(msgStr, "join|"), strings.HasPrefix(msgStr, "leave|"):
+		kv := strings.SplitN(msgStr, "|", 2)
+		room, err := parseRoomName(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: kv[0], Room: room}
+	case strings.HasPrefix(msgStr, "send|"):
+		room, body, err := parseSendFields(msgStr)
+		if err != nil {
+			return nil, err
+		}
+		parsed = parsedCommand{Command: "send", Room: room, Body: body}
+	case strings.HasPrefix(msgStr, "subscribe|"), strings.HasPrefix(msgStr, "unsubscribe|"):
+		kv := strings.SplitN(msgStr, "|", 2)
+		parsed = parsedCommand{Command: kv[0], Feed: kv[1]}
+	default:
+		return nil, errors.New("command not recognized")
+	}
+
+	document, _ := json.Marshal(parsed)
+	return append([]byte("parsed: "), document...), nil
+}
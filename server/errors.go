@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes sent to JSON clients along with the detail of the error
+const (
+	codeBadRequest       = "bad_request" // codeBadRequest is sent for errors without a more specific code
+	codeUnknownCommand   = "unknown_command"
+	codeBadRelayFormat   = "bad_relay_format"
+	codeUserNotFound     = "user_not_found"
+	codeBodyTooLarge     = "body_too_large"
+	codeTooManyReceivers = "too_many_receivers"
+	codeRateLimited      = "rate_limited"
+	codeNickTaken        = "nick_taken"
+	codeNoReplyTarget    = "no_reply_target"
+	codeQuotaExceeded    = "quota_exceeded"
+	codeMessageDropped   = "message_dropped"
+)
+
+// commandError is an error reported to the client along with its code
+type commandError struct {
+	code   string
+	detail string
+}
+
+func (e *commandError) Error() string {
+	return e.detail
+}
+
+// newCommandError returns an error reported to the client with the given code
+func newCommandError(code, format string, args ...interface{}) error {
+	return &commandError{code: code, detail: fmt.Sprintf(format, args...)}
+}
+
+// errorCode returns the code reported to the client for err
+func errorCode(err error) string {
+	var commandErr *commandError
+	if errors.As(err, &commandErr) {
+		return commandErr.code
+	}
+	return codeBadRequest
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+const defaultHistoryCapacity = 100
+
+// messageHistory keeps the last messages delivered to a user
+type messageHistory struct {
+	messages []*relayedMessage // messages is used as a ring once full, start being the oldest message
+	start    int
+}
+
+// add records a delivered message, dropping the oldest one past capacity
+func (h *messageHistory) add(message *relayedMessage, capacity int) {
+	if len(h.messages) < capacity {
+		h.messages = append(h.messages, message)
+		return
+	}
+	h.messages[h.start] = message
+	h.start = (h.start + 1) % len(h.messages)
+}
+
+// last returns up to n of the most recent messages, oldest first
+func (h *messageHistory) last(n int) []*relayedMessage {
+	if n > len(h.messages) {
+		n = len(h.messages)
+	}
+	messages := make([]*relayedMessage, 0, n)
+	for i := len(h.messages) - n; i < len(h.messages); i++ {
+		messages = append(messages, h.messages[(h.start+i)%len(h.messages)])
+	}
+	return messages
+}
+
+// recordHistory adds a message delivered to the client to its user history
+func (hub *Hub) recordHistory(c *client.Client, message *relayedMessage) {
+	if hub.historyCapacity <= 0 {
+		return
+	}
+	history, found := hub.history[c.ID]
+	if !found {
+		history = &messageHistory{}
+		hub.history[c.ID] = history
+	}
+	history.add(message, hub.historyCapacity)
+}
+
+// lastMessages returns up to count of the most recent messages delivered to the user
+func (hub *Hub) lastMessages(userID, count int) []*relayedMessage {
+	history, found := hub.history[userID]
+	if !found {
+		return nil
+	}
+	return history.last(count)
+}
+
+// parseHistoryCount parses the count of a history|count=N command
+func parseHistoryCount(msgStr string) (int, error) {
+	arg := strings.TrimPrefix(msgStr, "history|")
+	if !strings.HasPrefix(arg, "count=") {
+		return 0, fmt.Errorf("unexpected history argument: %s", arg)
+	}
+	count, err := strconv.Atoi(strings.TrimPrefix(arg, "count="))
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("history count should be a positive number, got %s", strings.TrimPrefix(arg, "count="))
+	}
+	return count, nil
+}
+
+// historyToBytes lists messages delivered to recipient the way they were relayed, oldest first
+func (hub *Hub) historyToBytes(recipient int, messages []*relayedMessage) []byte {
+	value := []byte("history: \n")
+	for _, message := range messages {
+		value = append(value, []byte(hub.messagePrefix(message, recipient))...)
+		value = append(value, message.body...)
+		value = append(value, '\n')
+	}
+	return value
+}
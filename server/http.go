@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// httpSenderID is the sender id attached to messages posted on the messages endpoint
+const httpSenderID = 0
+
+// httpRelay is a relay posted on the messages endpoint, it is delivered by the hub goroutine
+type httpRelay struct {
+	Users  []int  `json:"users"`
+	Body   string `json:"body"`
+	result chan *httpRelayResult
+}
+
+// httpRelayResult is the response of the messages endpoint
+type httpRelayResult struct {
+	Delivered []int `json:"delivered"`
+	Failed    []int `json:"failed"`
+	Queued    []int `json:"queued,omitempty"` // Queued are offline users the message was queued for
+}
+
+// postMessage relays the JSON message posted by a service that isn't connected via websocket
+func (hub *Hub) postMessage(w http.ResponseWriter, r *http.Request) {
+	if !hub.authorizedHTTP(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var relay httpRelay
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(2*hub.maxBodySize))).Decode(&relay); err != nil {
+		http.Error(w, fmt.Sprintf("invalid relay message: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(relay.Users) == 0 {
+		http.Error(w, "relay message should contain users", http.StatusBadRequest)
+		return
+	}
+	if len(relay.Users) > hub.maxReceivers {
+		http.Error(w, "max receivers per message exceeded", http.StatusBadRequest)
+		return
+	}
+	if err := hub.checkRelayBody(relay.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	relay.result = make(chan *httpRelayResult, 1)
+	select {
+	case hub.httpRelays <- &relay:
+	case <-hub.done:
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		http.Error(w, "hub not running", http.StatusServiceUnavailable)
+		return
+	}
+	result := <-relay.result
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// authorizedHTTP reports whether the request carries the hub bearer token
+func (hub *Hub) authorizedHTTP(r *http.Request) bool {
+	token := []byte("Bearer " + hub.httpToken)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) == 1
+}
+
+// deliverHTTPRelay delivers a message posted on the messages endpoint the way relays of clients are delivered
+func (hub *Hub) deliverHTTPRelay(relay *httpRelay) {
+	ack := newAckFrame("")
+	body, delivered := hub.intercept(httpSenderID, []byte(relay.Body))
+	if !delivered {
+		ack.Failed = relay.Users
+	} else {
+		destList := make([]string, len(relay.Users))
+		for i, userID := range relay.Users {
+			destList[i] = strconv.Itoa(userID)
+		}
+		hub.deliverRelay(nil, destList, hub.newRelayedMessage(httpSenderID, body), ack, false)
+	}
+	relay.result <- &httpRelayResult{Delivered: ack.Delivered, Failed: ack.Failed, Queued: ack.Queued}
+}
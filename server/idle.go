@@ -0,0 +1,32 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// startIdleTimer hands the client to the hub goroutine once it stays idle for the idle timeout, the read goroutine
+// resets the timer on every message. It returns nil when the idle timeout is disabled
+func (hub *Hub) startIdleTimer(c *client.Client) *time.Timer {
+	if hub.idleTimeout <= 0 {
+		return nil
+	}
+	return time.AfterFunc(hub.idleTimeout, func() {
+		select {
+		case hub.idle <- c:
+		case <-hub.done:
+		}
+	})
+}
+
+// closeIdle closes the connection of a client that stayed idle, unless it disconnected in the meantime.
+// read() then fails and routes the client through hub.disconnect
+func (hub *Hub) closeIdle(c *client.Client) {
+	if current, found := hub.lookupClient(c.ID); !found || current != c {
+		return
+	}
+	hub.logger.Infof("Client %s was idle for %v, closing connection", hub.identity(c), hub.idleTimeout)
+	hub.closeClient(c, websocket.CloseNormalClosure, "idle timeout", reasonIdleTimeout, true)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// Version is the version of the hub reported by the info command
+const Version = "0.1.0"
+
+// hubInfo is the document returned by the info command
+type hubInfo struct {
+	Type     string       `json:"type,omitempty"` // Type is only set in the frame answering JSON clients
+	Server   serverInfo   `json:"server"`
+	Features featuresInfo `json:"features"`
+	Limits   limitsInfo   `json:"limits"`
+	Client   clientInfo   `json:"client"`
+}
+
+type serverInfo struct {
+	Version string `json:"version"`
+}
+
+// featuresInfo describes the behaviour the hub was configured with
+type featuresInfo struct {
+	Authentication              bool     `json:"authentication"`
+	ReconnectPolicy             string   `json:"reconnect_policy"`
+	ReceiverOverflowPolicy      string   `json:"receiver_overflow_policy"`
+	RelayErrorVerbosity         string   `json:"relay_error_verbosity"`
+	RelayPrefix                 bool     `json:"relay_prefix"` // RelayPrefix is set when relayed messages get a custom prefix
+	SelfEcho                    bool     `json:"self_echo"`
+	AllowEmptyBody              bool     `json:"allow_empty_body"`
+	Interceptors                int      `json:"interceptors"`
+	Aliases                     int      `json:"aliases"`
+	MOTD                        bool     `json:"motd"`
+	RateLimiting                bool     `json:"rate_limiting"`
+	ByteQuota                   bool     `json:"byte_quota"`
+	History                     bool     `json:"history"`
+	OfflineQueue                bool     `json:"offline_queue"`
+	Compression                 bool     `json:"compression"`
+	CompressionThresholdBytes   int      `json:"compression_threshold_bytes"`
+	TLS                         bool     `json:"tls"`
+	AllowedOrigins              []string `json:"allowed_origins"`
+	HTTPSend                    bool     `json:"http_send"` // HTTPSend is set when messages can be posted on the messages endpoint
+	Debug                       bool     `json:"debug"`
+	CommandAudit                bool     `json:"command_audit"`
+	IdlePongs                   bool     `json:"idle_pongs"`
+	LogIdentity                 string   `json:"log_identity"`
+	MetricsIntervalSeconds      float64  `json:"metrics_interval_seconds"`
+	WriteLatencySeconds         float64  `json:"write_latency_seconds"`
+	WriteJitterSeconds          float64  `json:"write_jitter_seconds"`
+	PingIntervalSeconds         float64  `json:"ping_interval_seconds"`
+	SendBuffer                  int      `json:"send_buffer"`
+	DisconnectBuffer            int      `json:"disconnect_buffer"`
+	OfflineTTLSeconds           float64  `json:"offline_ttl_seconds"`
+	ByteQuotaWindowSeconds      float64  `json:"byte_quota_window_seconds"`
+	UnknownCommandWindowSeconds float64  `json:"unknown_command_window_seconds"`
+}
+
+// limitsInfo describes the global limits, a limit of 0 is disabled
+type limitsInfo struct {
+	MaxBodySize            int     `json:"max_body_size"`
+	MaxReceiversPerMessage int     `json:"max_receivers_per_message"`
+	MaxClients             int     `json:"max_clients"`
+	IdleTimeoutSeconds     float64 `json:"idle_timeout_seconds"`
+	RateLimitPerSecond     int     `json:"rate_limit_per_second"`
+	RateBurst              int     `json:"rate_burst"`
+	ByteQuota              int     `json:"byte_quota"`
+	HistoryCapacity        int     `json:"history_capacity"`
+	OfflineQueueSize       int     `json:"offline_queue_size"`
+	UnknownCommandLimit    int     `json:"unknown_command_limit"`
+}
+
+// clientLimitsInfo describes what the requesting client may still send
+type clientLimitsInfo struct {
+	MaxBodySize            int  `json:"max_body_size"`
+	MaxReceiversPerMessage int  `json:"max_receivers_per_message"`
+	ByteQuotaRemaining     *int `json:"byte_quota_remaining,omitempty"` // ByteQuotaRemaining is only set when a byte quota is configured
+	RateLimitRemaining     *int `json:"rate_limit_remaining,omitempty"` // RateLimitRemaining is only set when a rate limit is configured
+}
+
+type clientInfo struct {
+	ID     int              `json:"id"`
+	Limits clientLimitsInfo `json:"limits"`
+}
+
+// sendInfo answers the info command in the client protocol
+func (hub *Hub) sendInfo(c *client.Client) bool {
+	document := hub.info(c)
+	if c.JSON {
+		document.Type = "info"
+		return hub.sendJSON(c, document)
+	}
+	data, _ := json.Marshal(document)
+	return hub.sendText(c, data)
+}
+
+// info returns the server version, the configured features, global limits and the effective limits of the given client
+func (hub *Hub) info(c *client.Client) hubInfo {
+	clientLimits := clientLimitsInfo{MaxBodySize: hub.maxBodySize, MaxReceiversPerMessage: hub.maxReceivers}
+	if hub.byteQuota > 0 {
+		remaining := hub.byteQuota - hub.bytesUsed(c)
+		clientLimits.ByteQuotaRemaining = &remaining
+	}
+	if hub.rateLimit > 0 {
+		remaining := hub.messagesLeft(c)
+		clientLimits.RateLimitRemaining = &remaining
+	}
+	_, anonymous := hub.authenticator.(noopAuthenticator)
+	_, noAudit := hub.commandAuditSink.(noopCommandAuditSink)
+	allowedOrigins := hub.allowedOrigins
+	if allowedOrigins == nil {
+		allowedOrigins = []string{}
+	}
+
+	return hubInfo{
+		Server: serverInfo{Version: Version},
+		Features: featuresInfo{
+			Authentication:              !anonymous,
+			ReconnectPolicy:             reconnectPolicyName(hub.reconnectPolicy),
+			ReceiverOverflowPolicy:      receiverOverflowPolicyName(hub.receiverOverflowPolicy),
+			RelayErrorVerbosity:         relayErrorVerbosityName(hub.relayErrorVerbosity),
+			RelayPrefix:                 hub.relayPrefix != nil,
+			SelfEcho:                    hub.selfEcho,
+			AllowEmptyBody:              hub.allowEmptyBody,
+			Interceptors:                len(hub.interceptors),
+			Aliases:                     len(hub.aliases),
+			MOTD:                        hub.motd != nil,
+			RateLimiting:                hub.rateLimit > 0,
+			ByteQuota:                   hub.byteQuota > 0,
+			History:                     hub.historyCapacity > 0,
+			OfflineQueue:                hub.offlineQueueSize > 0,
+			Compression:                 hub.compression,
+			CompressionThresholdBytes:   hub.compressionThreshold,
+			TLS:                         hub.tlsCertFile != "",
+			AllowedOrigins:              allowedOrigins,
+			HTTPSend:                    hub.httpToken != "",
+			Debug:                       hub.debug,
+			CommandAudit:                !noAudit,
+			IdlePongs:                   hub.idlePongs,
+			LogIdentity:                 logIdentityName(hub.logIdentity),
+			MetricsIntervalSeconds:      hub.metricsInterval.Seconds(),
+			WriteLatencySeconds:         hub.writeLatency.Seconds(),
+			WriteJitterSeconds:          hub.writeJitter.Seconds(),
+			PingIntervalSeconds:         hub.pingInterval.Seconds(),
+			SendBuffer:                  hub.sendBuffer,
+			DisconnectBuffer:            hub.disconnectBuffer,
+			OfflineTTLSeconds:           hub.offlineTTL.Seconds(),
+			ByteQuotaWindowSeconds:      hub.byteQuotaWindow.Seconds(),
+			UnknownCommandWindowSeconds: hub.unknownCommandWindow.Seconds(),
+		},
+		Limits: limitsInfo{
+			MaxBodySize:            hub.maxBodySize,
+			MaxReceiversPerMessage: hub.maxReceivers,
+			MaxClients:             hub.maxClients,
+			IdleTimeoutSeconds:     hub.idleTimeout.Seconds(),
+			RateLimitPerSecond:     hub.rateLimit,
+			RateBurst:              hub.rateBurst,
+			ByteQuota:              hub.byteQuota,
+			HistoryCapacity:        hub.historyCapacity,
+			OfflineQueueSize:       hub.offlineQueueSize,
+			UnknownCommandLimit:    hub.unknownCommandLimit,
+		},
+		Client: clientInfo{ID: c.ID, Limits: clientLimits},
+	}
+}
+
+func reconnectPolicyName(policy ReconnectPolicy) string {
+	if policy == EvictOld {
+		return "evict_old"
+	}
+	return "reject_new"
+}
+
+func receiverOverflowPolicyName(policy ReceiverOverflowPolicy) string {
+	if policy == TruncateWithWarning {
+		return "truncate_with_warning"
+	}
+	return "reject"
+}
+
+func relayErrorVerbosityName(verbosity RelayErrorVerbosity) string {
+	if verbosity == Verbose {
+		return "verbose"
+	}
+	return "terse"
+}
+
+func logIdentityName(identity LogIdentity) string {
+	if identity == LogRemoteAddr {
+		return "remote_addr"
+	}
+	return "id"
+}
@@ -0,0 +1,16 @@
+package server
+
+// MessageInterceptor transforms the body of a message relayed by senderID.
+// It returns the body to deliver, or false to drop the message
+type MessageInterceptor func(senderID int, body []byte) ([]byte, bool)
+
+// intercept runs the body through the interceptors pipeline, stopping at the first one dropping the message
+func (hub *Hub) intercept(senderID int, body []byte) ([]byte, bool) {
+	for _, interceptor := range hub.interceptors {
+		var delivered bool
+		if body, delivered = interceptor(senderID, body); !delivered {
+			return nil, false
+		}
+	}
+	return body, true
+}
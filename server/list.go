@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+const (
+	defaultListPageSize = 50
+	maxListPageSize     = 1000
+)
+
+// listPage describes the page of users requested with list|offset=0,limit=50,sort=id
+type listPage struct {
+	offset int
+	limit  int
+	sort   string // sort is either "id" or "connected", for the connection time
+}
+
+func parseListPage(args string) (*listPage, error) {
+	page := &listPage{limit: defaultListPageSize, sort: "id"}
+	for _, arg := range strings.Split(args, ",") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unexpected list argument: %s", arg)
+		}
+		switch kv[0] {
+		case "offset":
+			offset, err := strconv.Atoi(kv[1])
+			if err != nil || offset < 0 {
+				return nil, fmt.Errorf("list offset should be a positive number, got %s", kv[1])
+			}
+			page.offset = offset
+		case "limit":
+			limit, err := strconv.Atoi(kv[1])
+			if err != nil || limit < 1 || limit > maxListPageSize {
+				return nil, fmt.Errorf("list limit should be between 1 and %d, got %s", maxListPageSize, kv[1])
+			}
+			page.limit = limit
+		case "sort":
+			if kv[1] != "id" && kv[1] != "connected" {
+				return nil, fmt.Errorf("list can only be sorted by id or connected, got %s", kv[1])
+			}
+			page.sort = kv[1]
+		default:
+			return nil, fmt.Errorf("unexpected list argument: %s", arg)
+		}
+	}
+	return page, nil
+}
+
+// clientsPageToBytes sorts the clients and lists the requested page, along with the total number of clients
+func clientsPageToBytes(clients []*client.Client, page *listPage) []byte {
+	sort.Slice(clients, func(i, j int) bool {
+		if page.sort == "connected" {
+			return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+
+	start := page.offset
+	if start > len(clients) {
+		start = len(clients)
+	}
+	end := start + page.limit
+	if end > len(clients) {
+		end = len(clients)
+	}
+
+	value := []byte(fmt.Sprintf("users list: total=%d\n", len(clients)))
+	for i, c := range clients[start:end] {
+		value = append(value, []byte(fmt.Sprintf("%d) %s\n", start+i, clientLabel(c)))...)
+	}
+	return value
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"io"
+	"log"
+	"strconv"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// Logger receives the logs of the hub. Programs shipping structured logs can plug their own logger with WithLogger
+type Logger interface {
+	Debugf(format string, args ...interface{}) // Debugf logs every command clients send and every relay
+	Infof(format string, args ...interface{})  // Infof logs clients connecting and disconnecting, and the hub starting and stopping
+	Errorf(format string, args ...interface{}) // Errorf logs failures of the hub itself
+}
+
+// stdLogger is the default Logger, it writes every level with the standard library logger
+type stdLogger struct {
+	logger *log.Logger
+}
+
+func newStdLogger(w io.Writer) *stdLogger {
+	return &stdLogger{logger: log.New(w, "", log.LstdFlags)}
+}
+
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Printf("DEBUG "+format, args...)
+}
+
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	l.logger.Printf("INFO "+format, args...)
+}
+
+func (l *stdLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Printf("ERROR "+format, args...)
+}
+
+// identity returns how the client is identified in the hub logs
+func (hub *Hub) identity(c *client.Client) string {
+	if hub.logIdentity == LogRemoteAddr {
+		return c.WS.RemoteAddr().String()
+	}
+	return strconv.Itoa(c.ID)
+}
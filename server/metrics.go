@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+const defaultMetricsInterval = time.Second * 5
+
+// subscribe registers the client to the given feed
+func (hub *Hub) subscribe(c *client.Client, feed string) error {
+	switch feed {
+	case "metrics":
+		hub.metricsSubscribers[c] = struct{}{}
+	case "presence":
+		// every client gets the presence feed, subscribing again undoes unsubscribe|presence
+		delete(hub.presenceMuted, c)
+	default:
+		return fmt.Errorf("unknown subscription feed: %s", feed)
+	}
+	hub.sendText(c, []byte("subscribed to "+feed))
+	return nil
+}
+
+// unsubscribe removes the client from the given feed, "all" removes it from every feed
+func (hub *Hub) unsubscribe(c *client.Client, feed string) error {
+	switch feed {
+	case "metrics":
+		delete(hub.metricsSubscribers, c)
+	case "presence":
+		hub.presenceMuted[c] = struct{}{}
+	case "all":
+		delete(hub.metricsSubscribers, c)
+		hub.presenceMuted[c] = struct{}{}
+	default:
+		return fmt.Errorf("unknown subscription feed: %s", feed)
+	}
+	hub.sendText(c, []byte("unsubscribed from "+feed))
+	return nil
+}
+
+// publishMetrics sends the connected clients count and the received messages rate to the metrics subscribers
+func (hub *Hub) publishMetrics() {
+	rate := float64(hub.receivedMessages) / hub.metricsInterval.Seconds()
+	hub.receivedMessages = 0
+
+	frame := []byte(fmt.Sprintf("metrics: clients=%d messages_per_second=%.2f", hub.clientsCount(), rate))
+	for c := range hub.metricsSubscribers {
+		hub.sendText(c, frame)
+	}
+}
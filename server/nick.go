@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// maxNickSize is the longest nickname a client may register
+const maxNickSize = 32
+
+// parseNick parses the name field of a nick|name=alice command
+func parseNick(msgStr string) (string, error) {
+	nick := strings.TrimPrefix(msgStr, "nick|")
+	if !strings.HasPrefix(nick, "name=") {
+		return "", errors.New("nick message should contain a name field")
+	}
+	return strings.TrimPrefix(nick, "name="), nil
+}
+
+func validateNick(nick string) error {
+	if nick == "" {
+		return errors.New("nickname can't be empty")
+	}
+	if len(nick) > maxNickSize || strings.ContainsAny(nick, ",;| ") {
+		return fmt.Errorf("nickname should be at most %d characters without spaces, , ; or |", maxNickSize)
+	}
+	if _, err := strconv.Atoi(nick); err == nil {
+		// relayed messages are prefixed with the nickname instead of the id, it must not pass for another user
+		return errors.New("nickname can't be a number")
+	}
+	return nil
+}
+
+// setNick registers nick for the client, replacing the nickname it had. Nicknames are unique among connected clients
+func (hub *Hub) setNick(c *client.Client, nick string) error {
+	if err := validateNick(nick); err != nil {
+		return err
+	}
+	if owner, taken := hub.nicks[nick]; taken && owner != c {
+		return newCommandError(codeNickTaken, "nickname %s is already taken", nick)
+	}
+	hub.releaseNick(c)
+	c.Nick = nick
+	hub.nicks[nick] = c
+	hub.sendText(c, []byte("nickname set to "+nick))
+	return nil
+}
+
+// releaseNick frees the nickname of the client, if it has one
+func (hub *Hub) releaseNick(c *client.Client) {
+	if c.Nick != "" && hub.nicks[c.Nick] == c {
+		delete(hub.nicks, c.Nick)
+	}
+}
+
+// clientLabel names a client in users lists, with its nickname when it has one
+func clientLabel(c *client.Client) string {
+	if c.Nick != "" {
+		return fmt.Sprintf("%d (%s)", c.ID, c.Nick)
+	}
+	return fmt.Sprint(c.ID)
+}
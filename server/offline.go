@@ -0,0 +1,68 @@
+package server
+
+import (
+	"time"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+const defaultOfflineTTL = 24 * time.Hour
+
+// queuedMessage is a message relayed to a user while it was offline
+type queuedMessage struct {
+	message  *relayedMessage
+	queuedAt time.Time
+}
+
+// canQueueOffline reports whether messages to the offline user are queued, the user must have been connected before
+func (hub *Hub) canQueueOffline(userID int) bool {
+	if hub.offlineQueueSize <= 0 {
+		return false
+	}
+	_, seen := hub.lastSeen[userID]
+	return seen
+}
+
+// queueOffline keeps a message for an offline user, dropping its oldest queued message when its queue is full
+func (hub *Hub) queueOffline(userID int, message *relayedMessage) {
+	queue := append(hub.offlineQueues[userID], queuedMessage{message: message, queuedAt: time.Now()})
+	if len(queue) > hub.offlineQueueSize {
+		queue = queue[len(queue)-hub.offlineQueueSize:]
+	}
+	hub.offlineQueues[userID] = queue
+}
+
+// flushOffline delivers, in order, the messages queued for the client while it was offline
+func (hub *Hub) flushOffline(c *client.Client) {
+	queue := hub.offlineQueues[c.ID]
+	delete(hub.offlineQueues, c.ID)
+	for _, queued := range queue {
+		if time.Since(queued.queuedAt) < hub.offlineTTL {
+			hub.deliver(c, queued.message)
+		}
+	}
+}
+
+// pruneOffline forgets the users and drops the queued messages that outlived the offline TTL,
+// so that users that never come back don't keep memory forever
+func (hub *Hub) pruneOffline() {
+	now := time.Now()
+	for userID, queue := range hub.offlineQueues {
+		kept := queue[:0]
+		for _, queued := range queue {
+			if now.Sub(queued.queuedAt) < hub.offlineTTL {
+				kept = append(kept, queued)
+			}
+		}
+		if len(kept) == 0 {
+			delete(hub.offlineQueues, userID)
+		} else {
+			hub.offlineQueues[userID] = kept
+		}
+	}
+	for userID, seenAt := range hub.lastSeen {
+		if _, connected := hub.lookupClient(userID); !connected && now.Sub(seenAt) >= hub.offlineTTL {
+			delete(hub.lastSeen, userID)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"io"
+	"time"
+)
+
+// Option configures optional behaviour of the Hub
+type Option func(*Hub)
+
+// ReceiverOverflowPolicy defines what the hub does with a relay addressed to more users than allowed by WithMaxReceivers
+type ReceiverOverflowPolicy int
+
+const (
+	// Reject refuses the whole relay message
+	Reject ReceiverOverflowPolicy = iota
+	// TruncateWithWarning delivers to as many of the first users as allowed and warns the sender
+	TruncateWithWarning
+)
+
+// ReconnectPolicy defines what the hub does when an authenticated user connects while it is already connected
+type ReconnectPolicy int
+
+const (
+	// RejectNew closes the new connection and keeps the existing one
+	RejectNew ReconnectPolicy = iota
+	// EvictOld closes the existing connection and registers the new one
+	EvictOld
+)
+
+// RelayErrorVerbosity defines how much detail the hub gives when a relay can't reach a recipient
+type RelayErrorVerbosity int
+
+const (
+	// Terse only reports the recipient that wasn't found
+	Terse RelayErrorVerbosity = iota
+	// Verbose reports the message id, the attempted recipient and a reason code
+	Verbose
+)
+
+// LogIdentity defines how clients are identified in the hub logs
+type LogIdentity int
+
+const (
+	// LogID identifies clients by their user id
+	LogID LogIdentity = iota
+	// LogRemoteAddr identifies clients by the address they connected from
+	LogRemoteAddr
+)
+
+// WithReceiverOverflowPolicy sets how relays addressed to too many users are handled, defaults to Reject
+func WithReceiverOverflowPolicy(policy ReceiverOverflowPolicy) Option {
+	return func(hub *Hub) {
+		hub.receiverOverflowPolicy = policy
+	}
+}
+
+// WithCommandAuditSink sets the sink receiving an audit record for every command handled by the hub
+func WithCommandAuditSink(sink CommandAuditSink) Option {
+	return func(hub *Hub) {
+		hub.commandAuditSink = sink
+	}
+}
+
+// WithMetricsInterval sets how often metrics subscribers receive a metrics frame, defaults to 5 seconds
+func WithMetricsInterval(interval time.Duration) Option {
+	return func(hub *Hub) {
+		hub.metricsInterval = interval
+	}
+}
+
+// WithWriteLatency delays every message written to clients by latency plus a random duration up to jitter.
+// It is meant for chaos testing client resilience and is off by default
+func WithWriteLatency(latency, jitter time.Duration) Option {
+	return func(hub *Hub) {
+		hub.writeLatency = latency
+		hub.writeJitter = jitter
+	}
+}
+
+// WithUnknownCommandLimit disconnects clients sending limit unknown commands within window, disabled by default
+func WithUnknownCommandLimit(limit int, window time.Duration) Option {
+	return func(hub *Hub) {
+		hub.unknownCommandLimit = limit
+		hub.unknownCommandWindow = window
+	}
+}
+
+// WithAliases sets command aliases, every alias is expanded to its sequence of commands when a client sends it
+func WithAliases(aliases map[string][]string) Option {
+	return func(hub *Hub) {
+		hub.aliases = aliases
+	}
+}
+
+// WithMOTD sets a message of the day delivered to every client when it connects
+func WithMOTD(motd string) Option {
+	return WithMOTDFunc(func() string { return motd })
+}
+
+// WithMOTDFunc sets a function returning the message of the day delivered to every client when it connects
+func WithMOTDFunc(motd func() string) Option {
+	return func(hub *Hub) {
+		hub.motd = motd
+	}
+}
+
+// WithByteQuota limits the number of bytes a client may relay, counting the body once per recipient.
+// Relays that would exceed the quota are rejected until the client reconnects or, when window isn't 0, the window elapses
+func WithByteQuota(quota int, window time.Duration) Option {
+	return func(hub *Hub) {
+		hub.byteQuota = quota
+		hub.byteQuotaWindow = window
+	}
+}
+
+// WithDebug enables the debug commands, such as headers, disabled by default
+func WithDebug(debug bool) Option {
+	return func(hub *Hub) {
+		hub.debug = debug
+	}
+}
+
+// WithAllowEmptyBody lets clients send messages with an empty or whitespace only body, such as heartbeats.
+// By default they are refused with a bad_relay_format error
+func WithAllowEmptyBody(allow bool) Option {
+	return func(hub *Hub) {
+		hub.allowEmptyBody = allow
+	}
+}
+
+// WithSelfEcho delivers relays to the sender when it lists its own id among the users, by default it is skipped
+func WithSelfEcho(echo bool) Option {
+	return func(hub *Hub) {
+		hub.selfEcho = echo
+	}
+}
+
+// WithRelayPrefixFunc sets the function building the prefix of relayed messages, it is called once per recipient
+func WithRelayPrefixFunc(prefix RelayPrefixFunc) Option {
+	return func(hub *Hub) {
+		hub.relayPrefix = prefix
+	}
+}
+
+// WithRelayErrorVerbosity sets how much detail relay failures report, defaults to Terse
+func WithRelayErrorVerbosity(verbosity RelayErrorVerbosity) Option {
+	return func(hub *Hub) {
+		hub.relayErrorVerbosity = verbosity
+	}
+}
+
+// WithAllowedOrigins sets the origins of the pages that may connect to the hub, "*" allows any origin.
+// By default only pages served from the hub host may connect
+func WithAllowedOrigins(origins []string) Option {
+	return func(hub *Hub) {
+		hub.allowedOrigins = origins
+	}
+}
+
+// WithTLS serves the hub over TLS with the given certificate and key files, so that clients connect with wss://
+func WithTLS(certFile, keyFile string) Option {
+	return func(hub *Hub) {
+		hub.tlsCertFile = certFile
+		hub.tlsKeyFile = keyFile
+	}
+}
+
+// WithHTTPToken enables the POST /messages endpoint, requests must send the token as an Authorization bearer token
+func WithHTTPToken(token string) Option {
+	return func(hub *Hub) {
+		hub.httpToken = token
+	}
+}
+
+// WithDisconnectBuffer sets the capacity of the disconnect channel, so that many clients
+// disconnecting while the hub is busy don't block, defaults to 64
+func WithDisconnectBuffer(size int) Option {
+	return func(hub *Hub) {
+		hub.disconnectBuffer = size
+	}
+}
+
+// WithInterceptors appends interceptors to the pipeline transforming relayed bodies, they run in the given order
+func WithInterceptors(interceptors ...MessageInterceptor) Option {
+	return func(hub *Hub) {
+		hub.interceptors = append(hub.interceptors, interceptors...)
+	}
+}
+
+// WithSendBuffer sets how many messages can be queued for a client, a client whose queue is full
+// is disconnected instead of blocking the hub, defaults to 256
+func WithSendBuffer(size int) Option {
+	return func(hub *Hub) {
+		hub.sendBuffer = size
+	}
+}
+
+// WithPingInterval sets how often clients are pinged, a client that doesn't answer
+// with a pong within two intervals is disconnected, defaults to 30s
+func WithPingInterval(interval time.Duration) Option {
+	return func(hub *Hub) {
+		hub.pingInterval = interval
+	}
+}
+
+// WithAuthenticator validates the token of every websocket handshake, the authenticated user id becomes the client id.
+// By default every client is accepted and assigned an id by the hub
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(hub *Hub) {
+		hub.authenticator = authenticator
+	}
+}
+
+// WithMaxBodySize sets the largest body a client may send, in bytes, defaults to 1024000.
+// Frames too large to hold such a body close the connection
+func WithMaxBodySize(size int) Option {
+	return func(hub *Hub) {
+		hub.maxBodySize = size
+	}
+}
+
+// WithReconnectPolicy sets what the hub does when an authenticated user connects while already connected, defaults to RejectNew
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(hub *Hub) {
+		hub.reconnectPolicy = policy
+	}
+}
+
+// WithIdleTimeout closes clients that send no message for the given duration, 0 disables it
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(hub *Hub) {
+		hub.idleTimeout = timeout
+	}
+}
+
+// WithIdlePongs counts the pongs answering the hub pings as activity, so that only clients that stopped
+// answering pings are idle. By default only messages count
+func WithIdlePongs(pongs bool) Option {
+	return func(hub *Hub) {
+		hub.idlePongs = pongs
+	}
+}
+
+// WithMaxClients limits the number of connected clients, handshakes beyond it are rejected with 503
+func WithMaxClients(n int) Option {
+	return func(hub *Hub) {
+		hub.maxClients = n
+	}
+}
+
+// WithMaxReceivers sets the largest number of users a message may be relayed to, defaults to 255
+func WithMaxReceivers(receivers int) Option {
+	return func(hub *Hub) {
+		hub.maxReceivers = receivers
+	}
+}
+
+// WithRateLimit limits every client to msgsPerSec messages per second, allowing bursts of up to burst messages.
+// Messages over the limit are refused and a client exceeding it 10 times in a row is disconnected
+func WithRateLimit(msgsPerSec, burst int) Option {
+	return func(hub *Hub) {
+		hub.rateLimit = msgsPerSec
+		hub.rateBurst = burst
+		if hub.rateBurst < 1 {
+			hub.rateBurst = 1
+		}
+	}
+}
+
+// WithHistoryCapacity sets how many of the last messages delivered to each user the hub keeps
+// for the history command, 0 disables it, defaults to 100
+func WithHistoryCapacity(capacity int) Option {
+	return func(hub *Hub) {
+		hub.historyCapacity = capacity
+	}
+}
+
+// WithOfflineQueue keeps up to maxPerUser messages relayed to users that were connected before but are offline,
+// they are delivered in order when the user connects again. The oldest message is dropped when the queue is full
+func WithOfflineQueue(maxPerUser int) Option {
+	return func(hub *Hub) {
+		hub.offlineQueueSize = maxPerUser
+	}
+}
+
+// WithOfflineTTL sets how long messages are queued for offline users, and how long users
+// that disconnected are remembered, defaults to 24h
+func WithOfflineTTL(ttl time.Duration) Option {
+	return func(hub *Hub) {
+		hub.offlineTTL = ttl
+	}
+}
+
+// WithCompression negotiates permessage-deflate with the clients that support it, frames smaller than the
+// compression threshold are still sent uncompressed. Compression is disabled by default
+func WithCompression(enabled bool) Option {
+	return func(hub *Hub) {
+		hub.compression = enabled
+	}
+}
+
+// WithCompressionThreshold sets the smallest frame, in bytes, compressed when compression is enabled, defaults to 1024
+func WithCompressionThreshold(bytes int) Option {
+	return func(hub *Hub) {
+		hub.compressionThreshold = bytes
+	}
+}
+
+// WithLogIdentity sets how clients are identified in the hub logs, defaults to LogID
+func WithLogIdentity(identity LogIdentity) Option {
+	return func(hub *Hub) {
+		hub.logIdentity = identity
+	}
+}
+
+// WithLogOutput sets where the default logger writes the hub logs, defaults to the standard output
+func WithLogOutput(w io.Writer) Option {
+	return func(hub *Hub) {
+		hub.logger = newStdLogger(w)
+	}
+}
+
+// WithLogger sends the hub logs to logger instead of the default logger
+func WithLogger(logger Logger) Option {
+	return func(hub *Hub) {
+		hub.logger = logger
+	}
+}
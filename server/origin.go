@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkOrigin accepts websocket handshakes sent from an allowed origin. Without allowed origins only pages served
+// from the hub host are accepted, "*" accepts any origin. Handshakes without an Origin header don't come from a
+// browser and are always accepted
+func (hub *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(hub.allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, allowed := range hub.allowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// trackUnknownCommand records an unknown command sent by the client and closes the connection
+// with a policy violation once the client exceeds the unknown command limit within the window
+func (hub *Hub) trackUnknownCommand(c *client.Client) {
+	if hub.unknownCommandLimit <= 0 {
+		return
+	}
+
+	now := time.Now()
+	recent := hub.unknownCommands[c][:0]
+	for _, sentAt := range hub.unknownCommands[c] {
+		if now.Sub(sentAt) < hub.unknownCommandWindow {
+			recent = append(recent, sentAt)
+		}
+	}
+	recent = append(recent, now)
+	hub.unknownCommands[c] = recent
+
+	if len(recent) < hub.unknownCommandLimit {
+		return
+	}
+	hub.logger.Infof("Client %s sent too many unknown commands, closing connection", hub.identity(c))
+	// read() fails and routes the client through hub.disconnect
+	hub.closeClient(c, websocket.ClosePolicyViolation, "too many unknown commands", reasonUnknownCommands, false)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// presenceFrame tells a JSON client that another client joined or left the hub
+type presenceFrame struct {
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	ID    int    `json:"id"`
+}
+
+// publishPresence tells every other connected client that c joined or left the hub, but the clients that
+// unsubscribed from presence
+func (hub *Hub) publishPresence(c *client.Client, event string) {
+	for _, subscriber := range hub.getAllUsersExcept(c.ID) {
+		if _, muted := hub.presenceMuted[subscriber]; muted {
+			continue
+		}
+		if subscriber.JSON {
+			hub.sendJSON(subscriber, presenceFrame{Type: "presence", Event: event, ID: c.ID})
+		} else {
+			hub.sendText(subscriber, []byte(fmt.Sprintf("presence: event=%s id=%d", event, c.ID)))
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// hubCollectors are the Prometheus metrics of a hub. Every hub has its own registry, so that several hubs can run
+// in the same process
+type hubCollectors struct {
+	registry         *prometheus.Registry
+	connectedClients prometheus.Gauge
+	messagesReceived prometheus.Counter
+	messagesRelayed  prometheus.Counter
+	relayErrors      *prometheus.CounterVec
+	bytesRelayed     prometheus.Counter
+}
+
+func newHubCollectors() *hubCollectors {
+	collectors := &hubCollectors{
+		registry: prometheus.NewRegistry(),
+		connectedClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "connected_clients",
+			Help: "Number of clients connected to the hub.",
+		}),
+		messagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "messages_received_total",
+			Help: "Messages received from clients.",
+		}),
+		messagesRelayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "messages_relayed_total",
+			Help: "Messages delivered to a recipient, once per recipient.",
+		}),
+		relayErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "relay_errors_total",
+			Help: "Relays that failed, as a whole or for a recipient, by error code.",
+		}, []string{"code"}),
+		bytesRelayed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bytes_relayed_total",
+			Help: "Body bytes delivered to recipients.",
+		}),
+	}
+	collectors.registry.MustRegister(collectors.connectedClients, collectors.messagesReceived,
+		collectors.messagesRelayed, collectors.relayErrors, collectors.bytesRelayed)
+	return collectors
+}
+
+// getMetrics serves the Prometheus metrics of the hub, it requires the bearer token when the hub has one
+func (hub *Hub) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if hub.httpToken != "" && !hub.authorizedHTTP(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	promhttp.HandlerFor(hub.collectors.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// Command is a message sent by a client with the JSON protocol, e.g. {"type":"relay","users":[1,2],"body":"hello"}
+type Command struct {
+	Type  string `json:"type"`
+	Users []int  `json:"users,omitempty"`
+	Body  string `json:"body,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Room  string `json:"room,omitempty"`
+	ID    string `json:"id,omitempty"` // ID is the correlation id of a relay, acknowledged once it is handled
+}
+
+// idFrame answers an id command
+type idFrame struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// listFrame answers a list command
+type listFrame struct {
+	Type  string `json:"type"`
+	Users []int  `json:"users"`
+}
+
+// messageFrame delivers a relayed message
+type messageFrame struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+	From int    `json:"from"`
+	Seq  int    `json:"seq,omitempty"`  // Seq orders deliveries across the hub, it is left out of history frames
+	TS   int64  `json:"ts"`             // TS is when the hub received the message, in unix milliseconds
+	Nick string `json:"nick,omitempty"` // Nick is the nickname of the sender when it has one
+	Room string `json:"room,omitempty"` // Room is set for messages sent to a room
+	Body string `json:"body"`
+}
+
+// historyFrame answers a history command, oldest message first
+type historyFrame struct {
+	Type     string         `json:"type"`
+	Messages []messageFrame `json:"messages"`
+}
+
+// errorFrame reports a command that failed
+type errorFrame struct {
+	Type   string `json:"type"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// textFrame carries any other reply of the hub
+type textFrame struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// parseMessage decodes a JSON message and validates the fields its type requires
+func parseMessage(data []byte) (Command, error) {
+	command, err := decodeMessage(data)
+	if err != nil {
+		return Command{}, err
+	}
+	return command, validateMessage(command)
+}
+
+// decodeMessage decodes a JSON message without validating it
+func decodeMessage(data []byte) (Command, error) {
+	var command Command
+	if err := json.Unmarshal(data, &command); err != nil {
+		return Command{}, fmt.Errorf("malformed JSON message: %v", err)
+	}
+	return command, nil
+}
+
+// validateMessage checks that a decoded JSON message has a known type and the fields that type requires
+func validateMessage(command Command) error {
+	switch command.Type {
+	case "id", "list", "info", "broadcast", "reply":
+	case "relay":
+		if len(command.Users) == 0 {
+			return newCommandError(codeBadRelayFormat, "relay message should contain users")
+		}
+	case "history":
+		if command.Count < 1 {
+			return errors.New("history count should be a positive number")
+		}
+	case "join", "leave", "send":
+		return validateRoomName(command.Room)
+	case "":
+		return errors.New("message should contain a type field")
+	default:
+		return newCommandError(codeUnknownCommand, "unknown message type: %s", command.Type)
+	}
+	return nil
+}
+
+// runJSONCommand executes a JSON message on behalf of the client with the given id.
+// Once the message decodes the client is switched to the JSON protocol, so every later reply it gets is a JSON frame
+func (hub *Hub) runJSONCommand(hubM *HubMessage, id int, data []byte) (string, error) {
+	command, err := decodeMessage(data)
+	if err != nil {
+		return "unknown", err
+	}
+	hubM.client.JSON = true
+
+	if err := validateMessage(command); err != nil {
+		switch command.Type {
+		case "relay", "history", "join", "leave", "send":
+			return command.Type, err
+		}
+		return "unknown", err
+	}
+
+	switch command.Type {
+	case "id":
+		hub.sendJSON(hubM.client, idFrame{Type: "id", ID: id})
+	case "list":
+		users := []int{}
+		for _, c := range hub.getAllUsersExcept(id) {
+			users = append(users, c.ID)
+		}
+		hub.sendJSON(hubM.client, listFrame{Type: "list", Users: users})
+	case "relay":
+		destList := make([]string, len(command.Users))
+		for i, userID := range command.Users {
+			destList[i] = strconv.Itoa(userID)
+		}
+		return "relay", hub.relay(hubM, &relayFields{users: destList, body: command.Body, ackID: command.ID})
+	case "info":
+		hub.sendInfo(hubM.client)
+	case "broadcast":
+		return "broadcast", hub.broadcast(hubM, command.Body)
+	case "reply":
+		return "reply", hub.reply(hubM, command.Body)
+	case "history":
+		messages := []messageFrame{}
+		for _, message := range hub.lastMessages(id, command.Count) {
+			messages = append(messages, message.frame())
+		}
+		hub.sendJSON(hubM.client, historyFrame{Type: "history", Messages: messages})
+	case "join":
+		return "join", hub.joinRoom(hubM.client, command.Room)
+	case "leave":
+		return "leave", hub.leaveRoom(hubM.client, command.Room)
+	case "send":
+		return "send", hub.sendToRoom(hubM, command.Room, command.Body)
+	}
+	return command.Type, nil
+}
+
+// sendText sends a reply of the hub in the client protocol
+func (hub *Hub) sendText(c *client.Client, text []byte) bool {
+	if c.JSON {
+		return hub.sendJSON(c, textFrame{Type: "text", Text: string(text)})
+	}
+	return hub.send(c, append([]byte("server: "), text...))
+}
+
+// sendError reports an error in the client protocol, the code is only sent to JSON clients
+func (hub *Hub) sendError(c *client.Client, code, detail string) bool {
+	if c.JSON {
+		return hub.sendJSON(c, errorFrame{Type: "error", Code: code, Detail: detail})
+	}
+	return hub.send(c, append([]byte("server: "), detail...))
+}
+
+// sendJSON sends frame encoded as JSON
+func (hub *Hub) sendJSON(c *client.Client, frame interface{}) bool {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		hub.logger.Errorf("Failed to encode frame for client %s: %v", hub.identity(c), err)
+		return false
+	}
+	return hub.send(c, data)
+}
+
+// relayedMessage is a message the hub delivers on behalf of a sender
+type relayedMessage struct {
+	id     int // id is assigned by the hub and increases with every relayed message
+	from   int
+	sentAt time.Time // sentAt is when the hub received the message
+	nick   string    // nick is the nickname the sender had when the message was sent
+	room   string    // room is the room the message was sent to, empty for relays and broadcasts
+	binary bool      // binary messages are delivered as their body alone, in a binary frame
+	body   []byte
+}
+
+// newRelayedMessage assigns the next message id to a message relayed from senderID
+func (hub *Hub) newRelayedMessage(senderID int, body []byte) *relayedMessage {
+	hub.lastMessageID++
+	message := &relayedMessage{id: hub.lastMessageID, from: senderID, sentAt: time.Now(), body: body}
+	if sender, found := hub.lookupClient(senderID); found {
+		message.nick = sender.Nick
+	}
+	return message
+}
+
+// deliver sends a relayed message in the recipient protocol, text and JSON frames carry the message id
+// while binary frames hold the body alone
+func (hub *Hub) deliver(recipient *client.Client, message *relayedMessage) bool {
+	var sent bool
+	if message.binary {
+		sent = hub.sendFrame(recipient, client.Frame{Type: websocket.BinaryMessage, Data: message.body})
+	} else if recipient.JSON {
+		frame := message.frame()
+		hub.lastSeq++
+		frame.Seq = hub.lastSeq
+		sent = hub.sendJSON(recipient, frame)
+	} else {
+		sent = hub.sendText(recipient, append([]byte(hub.messagePrefix(message, recipient.ID)), message.body...))
+	}
+	if sent {
+		if message.from != httpSenderID {
+			recipient.LastSender = message.from
+		}
+		hub.recordHistory(recipient, message)
+		hub.collectors.messagesRelayed.Inc()
+		hub.collectors.bytesRelayed.Add(float64(len(message.body)))
+	}
+	return sent
+}
+
+// frame returns the JSON frame delivering the message
+func (message *relayedMessage) frame() messageFrame {
+	return messageFrame{Type: "message", ID: message.id, From: message.from, TS: message.sentAt.UnixNano() / int64(time.Millisecond), Nick: message.nick, Room: message.room, Body: string(message.body)}
+}
+
+// messagePrefix returns the prefix of a message delivered to a pipe-delimited client, starting with the message id
+// and naming the room it was sent to if any, e.g. #17 [foo] 1->
+func (hub *Hub) messagePrefix(message *relayedMessage, recipientID int) string {
+	prefix := defaultRelayPrefix(message)
+	if hub.relayPrefix != nil {
+		prefix = hub.relayPrefix(message.from, recipientID)
+	}
+	if message.room != "" {
+		prefix = "[" + message.room + "] " + prefix
+	}
+	return fmt.Sprintf("#%d %s", message.id, prefix)
+}
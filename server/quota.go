@@ -0,0 +1,44 @@
+package server
+
+import (
+	"time"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// byteUsage keeps the bytes relayed by a client since the start of its quota window
+type byteUsage struct {
+	bytes int
+	since time.Time
+}
+
+// byteQuotaExceeded reports whether relaying n more bytes would take the client over its quota
+func (hub *Hub) byteQuotaExceeded(c *client.Client, n int) bool {
+	return hub.byteQuota > 0 && hub.bytesUsed(c)+n > hub.byteQuota
+}
+
+// bytesUsed returns the bytes the client relayed in its current quota window, starting a new window once it elapsed
+func (hub *Hub) bytesUsed(c *client.Client) int {
+	usage, found := hub.bytesSent[c]
+	if !found {
+		return 0
+	}
+	if hub.byteQuotaWindow > 0 && time.Since(usage.since) >= hub.byteQuotaWindow {
+		delete(hub.bytesSent, c)
+		return 0
+	}
+	return usage.bytes
+}
+
+// countBytesSent adds n relayed bytes to the client usage
+func (hub *Hub) countBytesSent(c *client.Client, n int) {
+	if hub.byteQuota <= 0 {
+		return
+	}
+	usage, found := hub.bytesSent[c]
+	if !found {
+		usage = &byteUsage{since: time.Now()}
+		hub.bytesSent[c] = usage
+	}
+	usage.bytes += n
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/gorilla/websocket"
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// maxRateLimitViolations is the number of messages in a row over the rate limit after which a client is disconnected
+const maxRateLimitViolations = 10
+
+// tokenBucket keeps the messages a client may still send right away
+type tokenBucket struct {
+	tokens     float64
+	last       time.Time
+	violations int // violations counts the messages in a row that were over the rate limit
+}
+
+// allowMessage takes a token from the client bucket, it reports false when the client exceeds its rate limit.
+// The connection is closed with a policy violation once the client exceeds it too many times in a row
+func (hub *Hub) allowMessage(c *client.Client) bool {
+	if hub.rateLimit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	bucket, found := hub.rateBuckets[c]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(hub.rateBurst), last: now}
+		hub.rateBuckets[c] = bucket
+	}
+	bucket.tokens = math.Min(float64(hub.rateBurst), bucket.tokens+now.Sub(bucket.last).Seconds()*float64(hub.rateLimit))
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.violations = 0
+		return true
+	}
+
+	bucket.violations++
+	if bucket.violations >= maxRateLimitViolations {
+		hub.logger.Infof("Client %s kept exceeding its rate limit, closing connection", hub.identity(c))
+		// read() fails and routes the client through hub.disconnect
+		hub.closeClient(c, websocket.ClosePolicyViolation, "rate limit exceeded", reasonRateLimited, true)
+	}
+	return false
+}
+
+// messagesLeft returns the number of messages the client may send right away without exceeding its rate limit
+func (hub *Hub) messagesLeft(c *client.Client) int {
+	bucket, found := hub.rateBuckets[c]
+	if !found {
+		return hub.rateBurst
+	}
+	return int(math.Min(float64(hub.rateBurst), bucket.tokens+time.Since(bucket.last).Seconds()*float64(hub.rateLimit)))
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// parseReplyBody parses the body of a reply|body=con command
+func parseReplyBody(msgStr string) (string, error) {
+	reply := strings.TrimPrefix(msgStr, "reply|")
+	if !strings.HasPrefix(reply, "body=") {
+		return "", errors.New("reply message should contain a body field")
+	}
+	return strings.TrimPrefix(reply, "body="), nil
+}
+
+// reply relays body to the last client that sent a message to the sender of message
+func (hub *Hub) reply(message *HubMessage, body string) error {
+	lastSender := message.client.LastSender
+	if lastSender == 0 {
+		return newCommandError(codeNoReplyTarget, "no message to reply to")
+	}
+	if _, found := hub.lookupClient(lastSender); !found {
+		return newCommandError(codeUserNotFound, "user %d is offline", lastSender)
+	}
+	return hub.relay(message, &relayFields{users: []string{strconv.Itoa(lastSender)}, body: body})
+}
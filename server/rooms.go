@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	client "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// maxRoomNameSize is the longest room name a client may join
+const maxRoomNameSize = 64
+
+// parseRoomName parses the room field of a join|room=foo or leave|room=foo command
+func parseRoomName(args string) (string, error) {
+	if !strings.HasPrefix(args, "room=") {
+		return "", errors.New("message should contain a room field")
+	}
+	room := strings.TrimPrefix(args, "room=")
+	return room, validateRoomName(room)
+}
+
+// parseSendFields parses the fields of a send|room=foo,body=con command
+func parseSendFields(msgStr string) (string, string, error) {
+	send := strings.TrimPrefix(msgStr, "send|")
+	fields := strings.SplitN(send, ",", 2)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "body=") {
+		return "", "", errors.New("send message should contain room and body fields")
+	}
+	room, err := parseRoomName(fields[0])
+	if err != nil {
+		return "", "", err
+	}
+	return room, strings.TrimPrefix(fields[1], "body="), nil
+}
+
+func validateRoomName(room string) error {
+	if room == "" {
+		return errors.New("room name can't be empty")
+	}
+	if len(room) > maxRoomNameSize || strings.ContainsAny(room, ",|") {
+		return fmt.Errorf("room name should be at most %d characters without , or |", maxRoomNameSize)
+	}
+	return nil
+}
+
+// joinRoom adds the client to the room, creating the room if nobody is in it yet
+func (hub *Hub) joinRoom(c *client.Client, room string) error {
+	if err := validateRoomName(room); err != nil {
+		return err
+	}
+	members, found := hub.rooms[room]
+	if !found {
+		members = make(map[int]*client.Client)
+		hub.rooms[room] = members
+	}
+	members[c.ID] = c
+	hub.sendText(c, []byte("joined room "+room))
+	return nil
+}
+
+// leaveRoom removes the client from the room, the room is deleted once its last member leaves
+func (hub *Hub) leaveRoom(c *client.Client, room string) error {
+	if _, member := hub.rooms[room][c.ID]; !member {
+		return fmt.Errorf("not a member of room %s", room)
+	}
+	hub.removeFromRoom(c, room)
+	hub.sendText(c, []byte("left room "+room))
+	return nil
+}
+
+func (hub *Hub) removeFromRoom(c *client.Client, room string) {
+	delete(hub.rooms[room], c.ID)
+	if len(hub.rooms[room]) == 0 {
+		delete(hub.rooms, room)
+	}
+}
+
+// leaveAllRooms removes a disconnected client from every room it belonged to
+func (hub *Hub) leaveAllRooms(c *client.Client) {
+	for room, members := range hub.rooms {
+		if members[c.ID] == c {
+			hub.removeFromRoom(c, room)
+		}
+	}
+}
+
+// sendToRoom delivers body to every member of the room but the one that sent message,
+// and tells the sender how many clients it reached
+func (hub *Hub) sendToRoom(message *HubMessage, room, body string) error {
+	senderID := message.client.ID
+	if _, member := hub.rooms[room][senderID]; !member {
+		return fmt.Errorf("not a member of room %s", room)
+	}
+
+	if err := hub.checkRelayBody(body); err != nil {
+		return err
+	}
+
+	payload, delivered := hub.intercept(senderID, []byte(body))
+	if !delivered {
+		return newCommandError(codeMessageDropped, "message dropped by the hub")
+	}
+
+	if hub.byteQuotaExceeded(message.client, len(payload)*(len(hub.rooms[room])-1)) {
+		return newCommandError(codeQuotaExceeded, "byte quota exceeded")
+	}
+
+	relayed := hub.newRelayedMessage(senderID, payload)
+	relayed.binary = message.binary()
+	relayed.room = room
+	recipients := 0
+	for id, destClient := range hub.rooms[room] {
+		if id != senderID && hub.deliver(destClient, relayed) {
+			hub.countBytesSent(message.client, len(payload))
+			recipients++
+		}
+	}
+	hub.sendText(message.client, []byte(fmt.Sprintf("room message delivered to %d clients", recipients)))
+	return nil
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -13,172 +20,904 @@ import (
 )
 
 const (
-	maxBodySize            = 1024000
-	maxReceiversPerMessage = 255
+	defaultMaxBodySize  = 1024000
+	defaultMaxReceivers = 255
+	maxUsersFieldSize   = 8192 // maxUsersFieldSize bounds the relay users field before it gets split
+	frameOverhead       = 1024 // frameOverhead bounds the command and field names framing a body
+
+	defaultDisconnectBuffer = 64  // defaultDisconnectBuffer lets disconnects queue up while the hub is busy
+	defaultSendBuffer       = 256 // defaultSendBuffer is the number of messages queued for a client before it is dropped
+
+	defaultCompressionThreshold = 1024 // defaultCompressionThreshold is the size below which frames are sent uncompressed
+
+	defaultPingInterval = 30 * time.Second // defaultPingInterval is the period between two pings sent to a client
+	writeWait           = 10 * time.Second // writeWait bounds every write to a client, so that a client that stopped reading can't stall its write goroutine
 )
 
 // HubMessage provides an helper to parse message and client details to the channel
 type HubMessage struct {
-	contents []byte
-	client   *client.Client
+	contents    []byte
+	messageType int // messageType is the websocket frame type the message was sent in
+	client      *client.Client
+}
+
+// binary reports whether the message was sent in a binary frame
+func (m *HubMessage) binary() bool {
+	return m.messageType == websocket.BinaryMessage
 }
 
-// Hub represents the server node. Which is able to receive and send messages to clients via websocket
+// registration hands a client that connected to the hub goroutine, which answers on result
+type registration struct {
+	client *client.Client
+	result chan error
+}
+
+// Hub represents the server node. Which is able to receive and send messages to clients via websocket.
+// Every per-client map is only accessed from the hub goroutine running handle. The clients map is
+// only written by it too, and guarded by clientsMu so that other goroutines can take a snapshot
 type Hub struct {
+	addr       string         // addr is the address the hub serves on
+	done       chan struct{}  // done is closed when the hub shuts down
+	goroutines sync.WaitGroup // goroutines tracks the hub and client goroutines, Run waits for them before returning
+
 	upgrader        websocket.Upgrader     // websocket to upgrade
 	messagesChannel chan *HubMessage       // messageChannel is used to read messages sent from clients
-	connect         chan *client.Client    // connect is used to notify when a client connects
+	connect         chan *registration     // connect is used to notify when a client connects
 	disconnect      chan *client.Client    // disconnect is used to notify when a client disconnects
-	clients         map[int]*client.Client // clients keeps connected clients
+	clients         map[int]*client.Client // clients keeps connected clients by their id, guarded by clientsMu
+	clientsMu       sync.RWMutex           // clientsMu guards clients, which is only written by the hub goroutine
+	lastID          int                    // lastID is the id assigned to the last connected client
+	lastMessageID   int                    // lastMessageID is the id assigned to the last relayed message
+	lastSeq         int                    // lastSeq is the sequence number of the last message frame delivered
+
+	disconnectBuffer int // disconnectBuffer is the capacity of the disconnect channel
+	sendBuffer       int // sendBuffer is the capacity of every client outbound Data channel
+
+	compression          bool // compression negotiates permessage-deflate with clients that support it
+	compressionThreshold int  // compressionThreshold is the smallest frame compressed when compression is negotiated
+
+	pingInterval time.Duration // pingInterval is the period between two pings sent to every client
+
+	idleTimeout time.Duration       // idleTimeout is how long a client may stay without sending a message, 0 disables it
+	idlePongs   bool                // idlePongs counts pongs as activity, so that only unresponsive clients are idle
+	idle        chan *client.Client // idle is used by the idle timers to hand idle clients to the hub goroutine
+
+	maxBodySize  int // maxBodySize is the largest body a client may send
+	maxReceivers int // maxReceivers is the largest number of users a message may be relayed to
+	maxClients   int // maxClients is the largest number of connected clients, 0 disables the limit
+
+	reconnectPolicy ReconnectPolicy // reconnectPolicy decides what happens when a user connects while already connected
+
+	authenticator Authenticator // authenticator validates the token of every websocket handshake
+
+	receiverOverflowPolicy ReceiverOverflowPolicy // receiverOverflowPolicy decides how relays with too many receivers are handled
+	commandAuditSink       CommandAuditSink       // commandAuditSink records every command handled by the hub
+
+	metricsInterval    time.Duration               // metricsInterval is the period between two metrics frames
+	metricsSubscribers map[*client.Client]struct{} // metricsSubscribers keeps clients subscribed to the metrics feed
+	receivedMessages   int                         // receivedMessages counts messages received since the last metrics frame
+	collectors         *hubCollectors              // collectors are the metrics served on GET /metrics
+
+	presenceMuted map[*client.Client]struct{} // presenceMuted keeps clients that unsubscribed from the join and leave events
+
+	rooms map[string]map[int]*client.Client // rooms keeps the members of every room by their id, rooms without members are deleted
+	nicks map[string]*client.Client         // nicks keeps connected clients by their nickname
+
+	writeLatency time.Duration // writeLatency is an artificial delay added before every write, for chaos testing only
+	writeJitter  time.Duration // writeJitter is the upper bound of a random delay added on top of writeLatency
+
+	unknownCommandLimit  int                            // unknownCommandLimit is the number of unknown commands after which a client is disconnected, 0 disables it
+	unknownCommandWindow time.Duration                  // unknownCommandWindow is the period over which unknown commands are counted
+	unknownCommands      map[*client.Client][]time.Time // unknownCommands keeps when each client recently sent unknown commands
+
+	aliases map[string][]string // aliases maps an alias to the commands it expands to
+	motd    func() string       // motd returns the message of the day delivered to clients when they connect
+
+	byteQuota       int                           // byteQuota is the number of relayed bytes a client may send, 0 disables it
+	byteQuotaWindow time.Duration                 // byteQuotaWindow is the period after which the quota is reset, 0 never resets it
+	bytesSent       map[*client.Client]*byteUsage // bytesSent keeps the bytes relayed by each client in the current window
+
+	rateLimit   int                             // rateLimit is the number of messages per second a client may send, 0 disables it
+	rateBurst   int                             // rateBurst is the number of messages a client may send at once
+	rateBuckets map[*client.Client]*tokenBucket // rateBuckets keeps the rate limit tokens left to each client
+
+	historyCapacity int                     // historyCapacity is the number of delivered messages kept for each user, 0 disables it
+	history         map[int]*messageHistory // history keeps the last messages delivered to each user id, across reconnections
+
+	offlineQueueSize int                     // offlineQueueSize is the number of messages queued for each offline user, 0 disables the queue
+	offlineTTL       time.Duration           // offlineTTL is how long messages are queued and users that disconnected are remembered
+	offlineQueues    map[int][]queuedMessage // offlineQueues keeps the messages relayed to offline users by their id
+	lastSeen         map[int]time.Time       // lastSeen keeps when users were last connected, only users seen before get messages queued
+
+	debug bool // debug enables commands meant to debug clients and proxies
+
+	logIdentity LogIdentity // logIdentity decides how clients are identified in the hub logs
+	logger      Logger      // logger receives the hub logs
+
+	relayPrefix RelayPrefixFunc // relayPrefix builds the prefix attached to relayed messages
+	selfEcho    bool            // selfEcho delivers relays to the sender when it lists its own id
+
+	allowEmptyBody bool // allowEmptyBody lets clients send bodies that are empty or only hold whitespace
+
+	relayErrorVerbosity RelayErrorVerbosity  // relayErrorVerbosity decides how much detail relay failures report
+	interceptors        []MessageInterceptor // interceptors transform relayed bodies, in order
+
+	allowedOrigins []string // allowedOrigins are the origins of the pages that may connect to the hub, empty allows the hub host only
+
+	tlsCertFile string // tlsCertFile is the certificate the hub serves over TLS, empty serves plain HTTP
+	tlsKeyFile  string // tlsKeyFile is the private key matching tlsCertFile
+
+	httpToken  string          // httpToken is the bearer token required to post on the messages endpoint, empty disables the endpoint
+	httpRelays chan *httpRelay // httpRelays is used to hand messages posted over HTTP to the hub goroutine
 }
 
-// InitHub starts an http server on the provided address and upgrades the connection to websockets
-func InitHub(addr string) {
-	fmt.Println("Starting hub on", addr)
-	hub := Hub{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
-		},
-		messagesChannel: make(chan *HubMessage),
-		connect:         make(chan *client.Client),
-		disconnect:      make(chan *client.Client),
-		clients:         make(map[int]*client.Client),
+// InitHub starts an http server on the provided address and upgrades the connection to websockets.
+// The hub shuts down gracefully on SIGINT or SIGTERM, InitHub returns the error that stopped the server, if any
+func InitHub(addr string, opts ...Option) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	return NewHub(addr, opts...).Run(ctx)
+}
+
+// NewHub provides a hub serving on the provided address, it doesn't accept connections until Run is called
+func NewHub(addr string, opts ...Option) *Hub {
+	hub := &Hub{
+		addr:                 addr,
+		done:                 make(chan struct{}),
+		messagesChannel:      make(chan *HubMessage),
+		httpRelays:           make(chan *httpRelay),
+		idle:                 make(chan *client.Client),
+		disconnectBuffer:     defaultDisconnectBuffer,
+		sendBuffer:           defaultSendBuffer,
+		compressionThreshold: defaultCompressionThreshold,
+		pingInterval:         defaultPingInterval,
+		clients:              make(map[int]*client.Client),
+		commandAuditSink:     noopCommandAuditSink{},
+		metricsInterval:      defaultMetricsInterval,
+		metricsSubscribers:   make(map[*client.Client]struct{}),
+		collectors:           newHubCollectors(),
+		presenceMuted:        make(map[*client.Client]struct{}),
+		rooms:                make(map[string]map[int]*client.Client),
+		nicks:                make(map[string]*client.Client),
+		unknownCommands:      make(map[*client.Client][]time.Time),
+		bytesSent:            make(map[*client.Client]*byteUsage),
+		rateBuckets:          make(map[*client.Client]*tokenBucket),
+		historyCapacity:      defaultHistoryCapacity,
+		history:              make(map[int]*messageHistory),
+		offlineTTL:           defaultOfflineTTL,
+		offlineQueues:        make(map[int][]queuedMessage),
+		lastSeen:             make(map[int]time.Time),
+		authenticator:        noopAuthenticator{},
+		logger:               newStdLogger(os.Stdout),
+	}
+	for _, opt := range opts {
+		opt(hub)
+	}
+	if hub.maxBodySize <= 0 {
+		hub.maxBodySize = defaultMaxBodySize
+	}
+	if hub.maxReceivers <= 0 {
+		hub.maxReceivers = defaultMaxReceivers
+	}
+	if hub.offlineTTL <= 0 {
+		hub.offlineTTL = defaultOfflineTTL
+	}
+	if hub.metricsInterval <= 0 {
+		hub.metricsInterval = defaultMetricsInterval
 	}
-	go hub.handle()
+	if hub.pingInterval <= 0 {
+		hub.pingInterval = defaultPingInterval
+	}
+	if hub.sendBuffer <= 0 {
+		hub.sendBuffer = defaultSendBuffer
+	}
+	if hub.disconnectBuffer < 0 {
+		hub.disconnectBuffer = defaultDisconnectBuffer
+	}
+	hub.upgrader.CheckOrigin = hub.checkOrigin
+	if _, anonymous := hub.authenticator.(noopAuthenticator); !anonymous {
+		// assigned ids get their own space, authenticated users may connect at any time with any id below it
+		hub.lastID = MaxAuthenticatedUserID
+	}
+	hub.upgrader.EnableCompression = hub.compression
+	// connect stays unbuffered so that a client is registered before its read and write goroutines start
+	hub.connect = make(chan *registration)
+	hub.disconnect = make(chan *client.Client, hub.disconnectBuffer)
+	return hub
+}
+
+// Run serves the hub until ctx is done, then stops accepting connections and closes every client
+// with a close frame. It returns once the hub and all client goroutines have exited, and may only be called once
+func (hub *Hub) Run(ctx context.Context) error {
+	hub.logger.Infof("Starting hub on %s", hub.addr)
+	server := &http.Server{Addr: hub.addr, Handler: hub.Router()}
+	hub.Start()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if hub.tlsCertFile != "" {
+			serveErr <- server.ListenAndServeTLS(hub.tlsCertFile, hub.tlsKeyFile)
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
 
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		hub.logger.Infof("Shutting down hub on %s", hub.addr)
+		err = server.Shutdown(context.Background())
+	}
+	hub.Close()
+	return err
+}
+
+// Router returns the handler serving the websocket and HTTP endpoints of the hub, for programs running their own
+// server, such as tests with httptest. The hub must be started with Start for websocket clients to be registered
+func (hub *Hub) Router() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/ws", hub.serveWS)
-	log.Fatal(http.ListenAndServe(addr, r))
+	if hub.httpToken != "" {
+		r.HandleFunc("/messages", hub.postMessage).Methods(http.MethodPost)
+	}
+	r.HandleFunc("/clients", hub.getClients).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", hub.getMetrics).Methods(http.MethodGet)
+	return r
+}
+
+// Start starts the hub goroutine, which registers clients and handles their messages. Run calls it,
+// programs serving Router themselves call it once before accepting connections
+func (hub *Hub) Start() {
+	hub.goroutines.Add(1)
+	go func() {
+		defer hub.goroutines.Done()
+		hub.handle()
+	}()
+}
+
+// Close closes every client with a close frame and stops the hub goroutine started by Start.
+// It returns once the hub and all client goroutines have exited, and may only be called once
+func (hub *Hub) Close() {
+	close(hub.done)
+	hub.goroutines.Wait()
 }
 
 func (hub *Hub) serveWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := hub.upgrader.Upgrade(w, r, nil)
+	userID, err := hub.authenticator.Authenticate(handshakeToken(r))
 	if err != nil {
-		http.Error(w, "", 500)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if hub.maxClients > 0 && hub.clientsCount() >= hub.maxClients {
+		// the hub goroutine checks the limit again when registering, clients may connect in the meantime
+		http.Error(w, errServerFull.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	client := &client.Client{WS: conn, Data: make(chan []byte)}
-	hub.connect <- client
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already answered, with 403 for an origin that isn't allowed
+	}
+
+	client := &client.Client{ID: userID, Authenticated: userID != 0, WS: conn, Data: make(chan client.Frame, hub.sendBuffer), Header: r.Header.Clone(), ConnectedAt: time.Now()}
+	if err := hub.register(client); err != nil {
+		// the hub doesn't know about the connection, nothing else would ever close it
+		switch err {
+		case errShuttingDown:
+			hub.refuseClient(client, websocket.CloseGoingAway, err.Error(), reasonShuttingDown, true)
+		case errServerFull:
+			hub.refuseClient(client, websocket.CloseTryAgainLater, err.Error(), reasonServerFull, true)
+		default:
+			hub.refuseClient(client, websocket.ClosePolicyViolation, err.Error(), reasonRejected, false)
+		}
+		return
+	}
 
 	go hub.read(client)
 	go hub.write(client)
 }
 
+// register hands a new client to the hub goroutine, it fails when the hub is shutting down
+// or when the authenticated user is already connected
+func (hub *Hub) register(c *client.Client) error {
+	// count the client goroutines before registering it, the hub may stop as soon as it is registered
+	hub.goroutines.Add(2)
+	reg := &registration{client: c, result: make(chan error, 1)}
+	select {
+	case hub.connect <- reg:
+		if err := <-reg.result; err != nil {
+			hub.goroutines.Add(-2)
+			return err
+		}
+		return nil
+	case <-hub.done:
+		hub.goroutines.Add(-2)
+		return errShuttingDown
+	}
+}
+
+// addClient registers a new client, assigning it an id unless it was authenticated
+func (hub *Hub) addClient(c *client.Client) error {
+	if c.ID < 0 || (c.Authenticated && c.ID > MaxAuthenticatedUserID) {
+		return errInvalidAuthUserID
+	}
+	if previous, found := hub.lookupClient(c.ID); found {
+		if hub.reconnectPolicy != EvictOld {
+			return errAlreadyConnected
+		}
+		hub.logger.Infof("Client %s connected again, closing its previous connection", hub.identity(previous))
+		hub.closeClient(previous, websocket.ClosePolicyViolation, "connected again", reasonReplaced, false)
+		hub.dropClient(previous)
+	}
+	if hub.maxClients > 0 && hub.clientsCount() >= hub.maxClients {
+		return errServerFull
+	}
+	for c.ID == 0 {
+		hub.lastID++
+		if !hub.userKnown(hub.lastID) {
+			c.ID = hub.lastID
+		}
+	}
+	hub.storeClient(c)
+	return nil
+}
+
+// userKnown tells whether the hub holds anything for the user id, so that it isn't assigned to another client
+func (hub *Hub) userKnown(id int) bool {
+	if _, found := hub.lookupClient(id); found {
+		return true
+	}
+	_, hasHistory := hub.history[id]
+	_, hasQueue := hub.offlineQueues[id]
+	_, seen := hub.lastSeen[id]
+	return hasHistory || hasQueue || seen
+}
+
+// dropClient forgets a client whose connection is gone and closes its Data channel. It does nothing
+// when the client was already dropped, e.g. when it was evicted by a new connection of the same user
+func (hub *Hub) dropClient(c *client.Client) {
+	if current, found := hub.lookupClient(c.ID); !found || current != c {
+		return
+	}
+	hub.removeClient(c.ID)
+	delete(hub.metricsSubscribers, c)
+	delete(hub.unknownCommands, c)
+	delete(hub.bytesSent, c)
+	delete(hub.rateBuckets, c)
+	if hub.offlineQueueSize > 0 && c.Authenticated {
+		// ids assigned by the hub are never reused, messages queued for them could never be delivered
+		hub.lastSeen[c.ID] = time.Now()
+	}
+	if !c.Authenticated {
+		// ids assigned by the hub are never reused, their history can't be fetched anymore
+		delete(hub.history, c.ID)
+	}
+	delete(hub.presenceMuted, c)
+	hub.leaveAllRooms(c)
+	hub.releaseNick(c)
+	// the leaving client is no longer subscribed, so nothing is sent to it once Data is closed
+	hub.publishPresence(c, "leave")
+	close(c.Data)
+	hub.logger.Infof("Client %s closed connection with the hub", hub.identity(c))
+}
+
 func (hub *Hub) handle() {
+	metricsTicker := time.NewTicker(hub.metricsInterval)
+	defer metricsTicker.Stop()
+	pruneTicker := time.NewTicker(hub.offlineTTL)
+	defer pruneTicker.Stop()
+
 	for {
 		select {
-		case connection := <-hub.connect:
-			add := connection.WS.RemoteAddr().String()
-			port, err := getPortFromAddress(add)
+		case reg := <-hub.connect:
+			connection := reg.client
+			err := hub.addClient(connection)
+			reg.result <- err
 			if err != nil {
-				fmt.Printf("connection error: %v", err)
-				hub.disconnect <- connection
-				return
+				hub.logger.Infof("Client %s was refused: %v", hub.identity(connection), err)
+				continue
+			}
+			hub.logger.Infof("A new client %s connected with the hub from %s", hub.identity(connection), connection.WS.RemoteAddr().String())
+			if hub.motd != nil {
+				hub.sendText(connection, []byte("motd: "+hub.motd()))
+			}
+			hub.publishPresence(connection, "join")
+			if hub.offlineQueueSize > 0 && connection.Authenticated {
+				hub.lastSeen[connection.ID] = time.Now()
+				hub.flushOffline(connection)
 			}
-			hub.clients[*port] = connection // port is used in the map to identify client
-			fmt.Printf("A new client connected with the hub from %s\n", connection.WS.RemoteAddr().String())
 		case disconnect := <-hub.disconnect:
-			close(disconnect.Data)
-			fmt.Printf("Client %s closed connection with the hub\n", disconnect.WS.RemoteAddr().String())
+			hub.dropClient(disconnect)
 
 		case message := <-hub.messagesChannel:
+			hub.receivedMessages++
+			hub.collectors.messagesReceived.Inc()
 			hub.handleMessage(message)
+
+		case relay := <-hub.httpRelays:
+			hub.deliverHTTPRelay(relay)
+
+		case <-metricsTicker.C:
+			hub.publishMetrics()
+
+		case <-pruneTicker.C:
+			hub.pruneOffline()
+
+		case c := <-hub.idle:
+			hub.closeIdle(c)
+
+		case <-hub.done:
+			hub.closeClients()
+			return
 		}
 	}
 }
 
+// closeClients sends a closing frame and a close frame to every connected client and closes its connection
+func (hub *Hub) closeClients() {
+	for _, c := range hub.getAllUsersExcept(httpSenderID) { // no client has the id of HTTP senders
+		hub.closeClient(c, websocket.CloseGoingAway, "server shutting down", reasonShuttingDown, true)
+		close(c.Data)
+		hub.removeClient(c.ID)
+	}
+}
+
 func (hub *Hub) handleMessage(hubM *HubMessage) {
-	add := hubM.client.WS.RemoteAddr().String()
-	// hubM.client.data <- []byte(fmt.Sprintf("I received your message %s", add))
+	// messages read before the client was dropped or replaced are ignored, its Data channel may be closed
+	if current, found := hub.lookupClient(hubM.client.ID); !found || current != hubM.client {
+		return
+	}
+	id := hubM.client.ID
+	msgStr := string(hubM.contents)
+	hub.logger.Debugf("from %s: %s", hub.identity(hubM.client), msgStr)
+
+	if !hub.allowMessage(hubM.client) {
+		hub.sendError(hubM.client, codeRateLimited, "rate limit exceeded")
+		return
+	}
 
-	port, err := getPortFromAddress(add)
+	commands, err := hub.expandAlias(msgStr, make(map[string]bool))
 	if err != nil {
-		fmt.Printf("connection error: %v", err)
-		hub.disconnect <- hubM.client
+		hub.sendError(hubM.client, errorCode(err), err.Error())
+		hub.commandAuditSink.RecordCommand(auditRecord(hubM.client, "alias", err.Error()))
 		return
 	}
+	for _, command := range commands {
+		hub.execute(hubM, id, command)
+	}
+}
 
-	msgStr := string(hubM.contents)
-	fmt.Printf("from %s: %s\n", add, msgStr)
+// execute runs a single command, reports its error to the client and records it in the audit trail
+func (hub *Hub) execute(hubM *HubMessage, id int, msgStr string) {
+	command, err := hub.runCommand(hubM, id, msgStr)
+	outcome := "ok"
+	if err != nil {
+		outcome = err.Error()
+		hub.sendError(hubM.client, errorCode(err), err.Error())
+		if command == "relay" {
+			hub.collectors.relayErrors.WithLabelValues(errorCode(err)).Inc()
+		}
+	}
+	hub.commandAuditSink.RecordCommand(auditRecord(hubM.client, command, outcome))
+
+	if command == "unknown" {
+		hub.trackUnknownCommand(hubM.client)
+	}
+}
+
+// runCommand executes the command in msgStr on behalf of the client with the given id.
+// It returns the name of the command and the error to report back to the client, if any
+func (hub *Hub) runCommand(hubM *HubMessage, id int, msgStr string) (string, error) {
+	if strings.HasPrefix(msgStr, "{") {
+		// messages starting with { use the JSON protocol, anything else is a pipe-delimited command
+		return hub.runJSONCommand(hubM, id, []byte(msgStr))
+	}
 
 	if msgStr == "id" {
-		hubM.client.Data <- []byte(fmt.Sprint(*port))
-		return
+		hub.sendText(hubM.client, []byte(fmt.Sprint(id)))
+		return "id", nil
 	}
 
 	if msgStr == "list" {
-		usersList := hub.getAllUsersExcept(*port)
-		hubM.client.Data <- clientsToBytes(usersList)
-		return
+		// The client can send a list message which the hub will answer with the list of all connected client user_id:s (excluding the requesting client).
+		usersList := hub.getAllUsersExcept(id)
+		hub.sendText(hubM.client, clientsToBytes(usersList))
+		return "list", nil
+	}
+
+	if strings.HasPrefix(msgStr, "list|") {
+		page, err := parseListPage(strings.TrimPrefix(msgStr, "list|"))
+		if err != nil {
+			return "list", err
+		}
+		hub.sendText(hubM.client, clientsPageToBytes(hub.getAllUsersExcept(id), page))
+		return "list", nil
+	}
+
+	if msgStr == "info" {
+		hub.sendInfo(hubM.client)
+		return "info", nil
+	}
+
+	if strings.HasPrefix(msgStr, "parse|") {
+		if !hub.debug {
+			return "parse", errors.New("debug commands are disabled")
+		}
+		parsed, err := parseCommand(strings.TrimPrefix(msgStr, "parse|"), hubM.binary())
+		if err != nil {
+			return "parse", err
+		}
+		hub.sendText(hubM.client, parsed)
+		return "parse", nil
+	}
+
+	if msgStr == "headers" {
+		if !hub.debug {
+			return "headers", errors.New("debug commands are disabled")
+		}
+		hub.sendText(hubM.client, headersToBytes(hubM.client.Header))
+		return "headers", nil
+	}
+
+	if strings.HasPrefix(msgStr, "history|") {
+		count, err := parseHistoryCount(msgStr)
+		if err != nil {
+			return "history", err
+		}
+		hub.sendText(hubM.client, hub.historyToBytes(id, hub.lastMessages(id, count)))
+		return "history", nil
 	}
 
 	if strings.HasPrefix(msgStr, "relay") {
-		// The client can send a list message which the hub will answer with the list of all connected client user_id:s (excluding the requesting client).
-		hub.parseRelayString(hubM)
-		return
+		return "relay", hub.parseRelayString(hubM, msgStr)
+	}
+
+	if strings.HasPrefix(msgStr, "broadcast|") {
+		return "broadcast", hub.parseBroadcastString(hubM, msgStr)
+	}
+
+	if strings.HasPrefix(msgStr, "reply|") {
+		body, err := parseReplyBody(msgStr)
+		if err != nil {
+			return "reply", err
+		}
+		return "reply", hub.reply(hubM, body)
+	}
+
+	if strings.HasPrefix(msgStr, "nick|") {
+		nick, err := parseNick(msgStr)
+		if err != nil {
+			return "nick", err
+		}
+		return "nick", hub.setNick(hubM.client, nick)
+	}
+
+	if strings.HasPrefix(msgStr, "join|") {
+		room, err := parseRoomName(strings.TrimPrefix(msgStr, "join|"))
+		if err != nil {
+			return "join", err
+		}
+		return "join", hub.joinRoom(hubM.client, room)
+	}
+
+	if strings.HasPrefix(msgStr, "leave|") {
+		room, err := parseRoomName(strings.TrimPrefix(msgStr, "leave|"))
+		if err != nil {
+			return "leave", err
+		}
+		return "leave", hub.leaveRoom(hubM.client, room)
+	}
+
+	if strings.HasPrefix(msgStr, "send|") {
+		room, body, err := parseSendFields(msgStr)
+		if err != nil {
+			return "send", err
+		}
+		return "send", hub.sendToRoom(hubM, room, body)
+	}
+
+	if strings.HasPrefix(msgStr, "subscribe|") {
+		return "subscribe", hub.subscribe(hubM.client, strings.TrimPrefix(msgStr, "subscribe|"))
+	}
+
+	if strings.HasPrefix(msgStr, "unsubscribe|") {
+		return "unsubscribe", hub.unsubscribe(hubM.client, strings.TrimPrefix(msgStr, "unsubscribe|"))
 	}
 
-	hubM.client.Data <- []byte("command not recognized")
+	return "unknown", newCommandError(codeUnknownCommand, "command not recognized")
+}
+
+func (hub *Hub) parseRelayString(message *HubMessage, msgStr string) error {
+	fields, err := parseRelayFields(msgStr, message.binary())
+	if err != nil {
+		return err
+	}
+	return hub.relay(message, fields)
+}
+
+// relayFields are the fields of a relay command
+type relayFields struct {
+	users  []string
+	body   string
+	dryRun bool
+	ackID  string // ackID is the correlation id of the ack sent back once the relay is handled, empty for no ack
 }
-func (hub *Hub) parseRelayString(message *HubMessage) {
+
+func parseRelayFields(msgStr string, binary bool) (*relayFields, error) {
 	// relay|users=u1;u2,body=con
-	relay := strings.TrimPrefix(string(message.contents), "relay|")
+	relay := strings.TrimPrefix(msgStr, "relay|")
 
-	relayArgs := strings.Split(relay, ",")
-	if len(relayArgs) != 2 {
-		message.client.Data <- []byte("relay message should contain users and body fields")
-		return
+	var usersArg, bodyArg string
+	var flags []string
+	if binary {
+		// binary bodies hold any byte, commas included: the body is everything after body=,
+		// so dryrun and id come before it, e.g. relay|users=u1;u2,id=abc,body=con
+		i := strings.Index(relay, ",body=")
+		if i < 0 {
+			return nil, newCommandError(codeBadRelayFormat, "relay message should contain users and body fields")
+		}
+		args := strings.Split(relay[:i], ",")
+		usersArg, flags, bodyArg = args[0], args[1:], relay[i+1:]
+	} else {
+		relayArgs := strings.Split(relay, ",")
+		if len(relayArgs) < 2 {
+			return nil, newCommandError(codeBadRelayFormat, "relay message should contain users and body fields")
+		}
+		usersArg, bodyArg, flags = relayArgs[0], relayArgs[1], relayArgs[2:]
+	}
+	if len(flags) > 2 {
+		return nil, newCommandError(codeBadRelayFormat, "relay message should contain users and body fields")
 	}
 
-	if !strings.HasPrefix(relayArgs[0], "users=") {
-		message.client.Data <- []byte("relay message should contain users field")
-		return
+	fields := &relayFields{}
+	for _, arg := range flags {
+		switch {
+		case arg == "dryrun=true" && !fields.dryRun:
+			// relay|users=u1;u2,body=con,dryrun=true validates and resolves the relay without delivering it
+			fields.dryRun = true
+		case arg == "dryrun=false":
+		case strings.HasPrefix(arg, "id=") && len(arg) > len("id=") && fields.ackID == "":
+			// relay|users=u1;u2,body=con,id=abc is acknowledged with the users it was delivered to
+			fields.ackID = strings.TrimPrefix(arg, "id=")
+		default:
+			return nil, newCommandError(codeBadRelayFormat, "relay message should only contain users, body, dryrun and id fields")
+		}
 	}
 
-	if !strings.HasPrefix(relayArgs[1], "body=") {
-		message.client.Data <- []byte("relay message should contain a body field")
-		return
+	if !strings.HasPrefix(usersArg, "users=") {
+		return nil, newCommandError(codeBadRelayFormat, "relay message should contain users field")
 	}
-	users := strings.TrimPrefix(relayArgs[0], "users=")
-	body := strings.TrimPrefix(relayArgs[1], "body=")
 
-	destList := strings.Split(users, ";")
-	if len(destList) == 0 {
-		message.client.Data <- []byte("unexpected message format")
-		return
+	if !strings.HasPrefix(bodyArg, "body=") {
+		return nil, newCommandError(codeBadRelayFormat, "relay message should contain a body field")
 	}
+	users := strings.TrimPrefix(usersArg, "users=")
+	body := strings.TrimPrefix(bodyArg, "body=")
 
-	if len(destList) > maxReceiversPerMessage {
-		message.client.Data <- []byte("max receivers per message exceeded")
-		return
+	if len(users) > maxUsersFieldSize {
+		// reject before splitting, a huge list of separators would otherwise allocate a huge slice
+		return nil, newCommandError(codeBadRelayFormat, "relay users field can't exceed %d bytes", maxUsersFieldSize)
 	}
 
-	if len(body) > maxBodySize {
-		message.client.Data <- []byte("message body can't exceed 1024kb")
-		return
+	destList, err := parseRelayUsers(users)
+	if err != nil {
+		return nil, err
+	}
+	fields.users, fields.body = destList, body
+	return fields, nil
+}
+
+// parseRelayUsers validates the entries of a relay users field, e.g. 5;6|fallback=7, and drops repeated entries.
+// Whitespace around ids and a trailing separator are tolerated
+func parseRelayUsers(users string) ([]string, error) {
+	entries := strings.Split(users, ";")
+	if len(entries) > 1 && strings.TrimSpace(entries[len(entries)-1]) == "" {
+		entries = entries[:len(entries)-1]
+	}
+
+	destList := make([]string, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		ids := strings.SplitN(entry, "|fallback=", 2)
+		for i, id := range ids {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				return nil, newCommandError(codeBadRelayFormat, "relay users can't contain an empty id")
+			}
+			if _, err := strconv.Atoi(id); err != nil {
+				return nil, newCommandError(codeBadRelayFormat, "invalid user id in relay users: %s", id)
+			}
+			ids[i] = id
+		}
+		entry = strings.Join(ids, "|fallback=")
+		if !seen[entry] {
+			seen[entry] = true
+			destList = append(destList, entry)
+		}
+	}
+	return destList, nil
+}
+
+// relay delivers body to the users in destList on behalf of the client that sent message.
+// With dryRun the relay is validated and resolved without being delivered
+func (hub *Hub) relay(message *HubMessage, fields *relayFields) error {
+	destList, body, dryRun := fields.users, fields.body, fields.dryRun
+	if len(destList) > hub.maxReceivers {
+		if hub.receiverOverflowPolicy != TruncateWithWarning {
+			return newCommandError(codeTooManyReceivers, "max receivers per message exceeded")
+		}
+		destList = destList[:hub.maxReceivers]
+		hub.sendText(message.client, []byte(fmt.Sprintf("max receivers per message exceeded, delivering to the first %d users", hub.maxReceivers)))
+	}
+
+	if err := hub.checkRelayBody(body); err != nil {
+		return err
+	}
+
+	senderID := message.client.ID
+	payload, delivered := hub.intercept(senderID, []byte(body))
+	if !delivered {
+		return newCommandError(codeMessageDropped, "message dropped by the hub")
 	}
 
-	senderID, _ := getPortFromAddress(message.client.WS.RemoteAddr().String())
+	// every recipient counts, so that a single relay can't take the client over its quota
+	if hub.byteQuotaExceeded(message.client, len(payload)*len(destList)) {
+		return newCommandError(codeQuotaExceeded, "byte quota exceeded")
+	}
+
+	var relayed *relayedMessage
+	if !dryRun {
+		relayed = hub.newRelayedMessage(senderID, payload)
+		relayed.binary = message.binary()
+	}
+	var ack *ackFrame
+	if fields.ackID != "" && !dryRun {
+		ack = newAckFrame(fields.ackID)
+	}
+	recipients := hub.deliverRelay(message.client, destList, relayed, ack, dryRun)
+
+	if ack != nil {
+		hub.sendAck(message.client, ack)
+	}
+
+	if dryRun {
+		hub.sendText(message.client, []byte(fmt.Sprintf("dry run: relay would be delivered to %d users", recipients)))
+		return nil
+	}
+	hub.logger.Debugf("Relayed message %d from %s to %d users", relayed.id, hub.identity(message.client), recipients)
+	return nil
+}
+
+// deliverRelay delivers relayed to the users in destList on behalf of sender, nil for messages posted over HTTP,
+// and returns the number of users it was delivered or queued to. Every user gets a single copy, offline users get it
+// once they connect again when the hub keeps offline queues. The outcome for every user is recorded in ack, without
+// an ack the sender is told about the users it couldn't reach. With dryRun the users are resolved and counted only
+func (hub *Hub) deliverRelay(sender *client.Client, destList []string, relayed *relayedMessage, ack *ackFrame, dryRun bool) int {
+	recipients := 0
+	reached := make(map[int]bool, len(destList)) // reached keeps the users the message was handled for, so nobody gets it twice
 	for _, u := range destList {
-		userID, _ := strconv.Atoi(u)
-		destClient, found := hub.clients[userID]
-		if !found {
+		userID, destClient := hub.resolveRecipient(u)
+		if reached[userID] {
+			continue
+		}
+		reached[userID] = true
+		if destClient == nil && hub.canQueueOffline(userID) {
+			recipients++
+			if dryRun {
+				continue
+			}
+			hub.queueOffline(userID, relayed)
+			if ack != nil {
+				ack.Queued = append(ack.Queued, userID)
+			} else {
+				hub.sendText(sender, []byte(fmt.Sprintf("user %d is offline, message queued", userID)))
+			}
+		} else if destClient == nil {
+			hub.collectors.relayErrors.WithLabelValues(codeUserNotFound).Inc()
+			if ack != nil {
+				ack.Failed = append(ack.Failed, userID)
+				continue
+			}
 			// if user in the provided list can't be found, return to the client the error
-			message.client.Data <- []byte(fmt.Sprintf("userid not found: %s", u))
+			hub.sendError(sender, codeUserNotFound, hub.relayFailure(relayed, u, codeUserNotFound))
+		} else if destClient == sender && !hub.selfEcho {
+			// senders don't get their own messages back unless the hub echoes them
+			continue
 		} else {
+			recipients++
+			if dryRun {
+				continue
+			}
 			// if user in the provided list is active, send the message and attach the user that sent it
-			userName := []byte(fmt.Sprintf("%d-> ", *senderID))
-			destClient.Data <- append(userName, body...)
+			sent := hub.deliver(destClient, relayed)
+			if sent && sender != nil {
+				hub.countBytesSent(sender, len(relayed.body))
+			}
+			if ack != nil {
+				ack.record(destClient.ID, sent)
+			}
 		}
 	}
+	return recipients
+}
+
+// checkRelayBody refuses a body exceeding maxBodySize, or empty unless the hub allows it
+func (hub *Hub) checkRelayBody(body string) error {
+	if len(body) > hub.maxBodySize {
+		return hub.bodyTooLarge()
+	}
+	return hub.checkEmptyBody(body)
+}
+
+// bodyTooLarge reports a body exceeding maxBodySize
+func (hub *Hub) bodyTooLarge() error {
+	if hub.maxBodySize%1000 == 0 {
+		return newCommandError(codeBodyTooLarge, "message body can't exceed %dkb", hub.maxBodySize/1000)
+	}
+	return newCommandError(codeBodyTooLarge, "message body can't exceed %d bytes", hub.maxBodySize)
+}
+
+// checkEmptyBody refuses a body that is empty or only holds whitespace, unless the hub allows it
+func (hub *Hub) checkEmptyBody(body string) error {
+	if !hub.allowEmptyBody && strings.TrimSpace(body) == "" {
+		return newCommandError(codeBadRelayFormat, "message body can't be empty")
+	}
+	return nil
+}
+
+// resolveRecipient returns the connected client an entry of the relay users list is delivered to.
+// An entry is either a user id or a user id with a fallback used when it is offline, e.g. 5|fallback=6.
+// Entries are validated by parseRelayUsers, or built from ids
+func (hub *Hub) resolveRecipient(entry string) (int, *client.Client) {
+	primary, fallback := entry, ""
+	if i := strings.Index(entry, "|fallback="); i >= 0 {
+		primary, fallback = entry[:i], entry[i+len("|fallback="):]
+	}
+
+	userID, _ := strconv.Atoi(primary)
+	if destClient, found := hub.lookupClient(userID); found || fallback == "" {
+		return userID, destClient
+	}
+	fallbackID, _ := strconv.Atoi(fallback)
+	destClient, _ := hub.lookupClient(fallbackID)
+	return fallbackID, destClient
+}
+
+// relayFailure describes why the relay of message to recipient failed, with the configured verbosity.
+// message is nil for dry runs, which relay nothing
+func (hub *Hub) relayFailure(message *relayedMessage, recipient, reason string) string {
+	if hub.relayErrorVerbosity != Verbose {
+		return fmt.Sprintf("userid not found: %s", recipient)
+	}
+	if message == nil {
+		return fmt.Sprintf("relay failed: recipient=%s reason=%s", recipient, reason)
+	}
+	return fmt.Sprintf("relay failed: message=%d recipient=%s reason=%s", message.id, recipient, reason)
+}
+
+// RelayPrefixFunc returns the prefix attached to a message relayed from senderID to recipientID
+type RelayPrefixFunc func(senderID, recipientID int) string
+
+// defaultRelayPrefix attaches the nickname of the user that sent the message, or its id when it has none
+func defaultRelayPrefix(message *relayedMessage) string {
+	if message.nick != "" {
+		return message.nick + "-> "
+	}
+	return fmt.Sprintf("%d-> ", message.from)
 }
 
 func clientsToBytes(clients []*client.Client) []byte {
 	value := []byte("users list: \n")
 	for i, c := range clients {
-		id, _ := getPortFromAddress(c.WS.RemoteAddr().String())
-		bValue := append([]byte(fmt.Sprint(i)+") "), []byte(fmt.Sprint(*id))...)
+		bValue := append([]byte(fmt.Sprint(i)+") "), []byte(clientLabel(c))...)
 		bValue = append(bValue, []byte("\n")...)
 		value = append(value, bValue...)
 	}
@@ -186,6 +925,8 @@ func clientsToBytes(clients []*client.Client) []byte {
 }
 
 func (hub *Hub) getAllUsersExcept(user int) []*client.Client {
+	hub.clientsMu.RLock()
+	defer hub.clientsMu.RUnlock()
 	clients := make([]*client.Client, 0, len(hub.clients))
 	for k, v := range hub.clients {
 		// exclude itself from list
@@ -196,41 +937,101 @@ func (hub *Hub) getAllUsersExcept(user int) []*client.Client {
 	return clients
 }
 
-func getPortFromAddress(a string) (*int, error) {
-	portStr := strings.Split(a, ":")
-	if len(portStr) != 2 {
-		return nil, fmt.Errorf("error reading the address: %s", a)
-	}
-	port, err := strconv.Atoi(portStr[1])
-	if err != nil {
-		return nil, fmt.Errorf("error converting port: %s", portStr[1])
-	}
-	return &port, nil
-}
-
 func (hub *Hub) read(client *client.Client) {
+	defer hub.goroutines.Done()
+	// frames that can't hold a valid body are rejected before being buffered
+	client.WS.SetReadLimit(int64(hub.maxBodySize + maxUsersFieldSize + frameOverhead))
+	// a client that stops answering pings is dropped when the read deadline is missed
+	pongWait := 2 * hub.pingInterval
+	client.WS.SetReadDeadline(time.Now().Add(pongWait))
+	idle := hub.startIdleTimer(client)
+	if idle != nil {
+		defer idle.Stop()
+	}
+	client.WS.SetPongHandler(func(string) error {
+		if idle != nil && hub.idlePongs {
+			idle.Reset(hub.idleTimeout)
+		}
+		return client.WS.SetReadDeadline(time.Now().Add(pongWait))
+	})
 	for {
-		_, msg, err := client.WS.ReadMessage()
+		messageType, msg, err := client.WS.ReadMessage()
+		if err == nil && idle != nil {
+			idle.Reset(hub.idleTimeout)
+		}
 		if err != nil {
-			hub.disconnect <- client
+			select {
+			case hub.disconnect <- client:
+			case <-hub.done:
+			}
 			client.WS.Close()
 			break
 		}
 		if len(msg) > 0 {
-			hub.messagesChannel <- &HubMessage{contents: msg, client: client}
+			select {
+			case hub.messagesChannel <- &HubMessage{contents: msg, messageType: messageType, client: client}:
+			case <-hub.done:
+			}
 		}
 
 	}
 }
 
+// send queues data for the client without blocking the hub. When the client outbound buffer is full
+// the client can't keep up: its connection is closed so that it goes through hub.disconnect
+func (hub *Hub) send(c *client.Client, data []byte) bool {
+	return hub.sendFrame(c, client.Frame{Type: websocket.TextMessage, Data: data})
+}
+
+// sendFrame queues a frame of any type for the client, the same way as send
+func (hub *Hub) sendFrame(c *client.Client, frame client.Frame) bool {
+	select {
+	case c.Data <- frame:
+		return true
+	default:
+		hub.logger.Infof("Client %s can't keep up with its messages, closing connection", hub.identity(c))
+		c.WS.Close()
+		return false
+	}
+}
+
 func (hub *Hub) write(client *client.Client) {
+	defer hub.goroutines.Done()
+	pingTicker := time.NewTicker(hub.pingInterval)
+	defer pingTicker.Stop()
 	for {
 		select {
 		case message, ok := <-client.Data:
 			if !ok {
 				return
 			}
-			client.WS.WriteMessage(1, append([]byte("server: "), message...))
+			if message.Type == websocket.CloseMessage {
+				// queued by closeClient, nothing is written after it
+				client.WS.WriteControl(websocket.CloseMessage, message.Data, time.Now().Add(writeWait))
+				client.WS.Close()
+				return
+			}
+			hub.injectWriteLatency()
+			// small frames aren't worth deflating, this has no effect unless the client negotiated compression
+			client.WS.EnableWriteCompression(hub.compression && len(message.Data) >= hub.compressionThreshold)
+			client.WS.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.WS.WriteMessage(message.Type, message.Data); err != nil {
+				// read() fails and routes the client through hub.disconnect
+				client.WS.Close()
+			}
+		case <-pingTicker.C:
+			client.WS.WriteControl(websocket.PingMessage, nil, time.Now().Add(hub.pingInterval))
 		}
 	}
 }
+
+// injectWriteLatency sleeps for the configured chaos testing latency and jitter, if any
+func (hub *Hub) injectWriteLatency() {
+	delay := hub.writeLatency
+	if hub.writeJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(hub.writeJitter)))
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,32 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRelayAck(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello,id=abc", clientY.ID, unknownUserID)))
+	clientY.expectMessage(t)
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf("server: ack: id=abc delivered=%s failed=%s", clientY.ID, unknownUserID) {
+		t.Fatalf("unexpected ack: got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestJSONRelayAck(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf(`{"type":"relay","users":[%s,%s],"body":"hello","id":"abc"}`, clientY.ID, unknownUserID)))
+	clientY.expectMessage(t)
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf(`{"type":"ack","id":"abc","delivered":[%s],"failed":[%s]}`, clientY.ID, unknownUserID) {
+		t.Fatalf("unexpected ack: got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestAlias(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAliases(map[string][]string{
+		"whoami": {"id", "list"},
+		"hello":  {"whoami"},
+	}))
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("hello"))
+	if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+		t.Fatalf("unexpected response from server: expected the id, got %q", msg)
+	}
+	if msg := clientX.expectMessage(t); !strings.HasPrefix(msg, "server: users list:") {
+		t.Fatalf("unexpected response from server: expected the users list, got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestRecursiveAlias(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAliases(map[string][]string{
+		"ping": {"id", "pong"},
+		"pong": {"ping"},
+	}))
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("ping"))
+	if msg := clientX.expectMessage(t); msg != "server: recursive alias: ping" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+type capturingAuditSink struct {
+	records chan msgSystemHub.CommandAuditRecord
+}
+
+func (s *capturingAuditSink) RecordCommand(record msgSystemHub.CommandAuditRecord) {
+	s.records <- record
+}
+
+func (s *capturingAuditSink) expectRecord(t *testing.T, command, outcome string) msgSystemHub.CommandAuditRecord {
+	t.Helper()
+	select {
+	case record := <-s.records:
+		if record.Command != command || record.Outcome != outcome {
+			t.Fatalf("unexpected audit record: expected %s with outcome %q, got %+v", command, outcome, record)
+		}
+		return record
+	case <-time.After(responseTimeout):
+		t.Fatalf("no audit record received for command %s", command)
+		return msgSystemHub.CommandAuditRecord{}
+	}
+}
+
+func TestCommandAuditSink(t *testing.T) {
+	sink := &capturingAuditSink{records: make(chan msgSystemHub.CommandAuditRecord, 16)}
+	address := startHub(t, msgSystemHub.WithCommandAuditSink(sink))
+	clientX := newTestClient(address) // newTestClient sends an id command
+
+	record := sink.expectRecord(t, "id", "ok")
+	if strconv.Itoa(record.ClientID) != clientX.ID {
+		t.Fatalf("audit record should carry the client id, got %+v", record)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("relay|users=1"))
+	clientX.expectMessage(t)
+	sink.expectRecord(t, "relay", "relay message should contain users and body fields")
+
+	clientX.WS.WriteMessage(1, []byte("hello"))
+	clientX.expectMessage(t)
+	sink.expectRecord(t, "unknown", "command not recognized")
+
+	// name changes and joins are recorded with their outcome
+	clientX.WS.WriteMessage(1, []byte("nick|name=alice"))
+	clientX.expectMessage(t)
+	sink.expectRecord(t, "nick", "ok")
+
+	clientX.WS.WriteMessage(1, []byte("join|room=foo"))
+	clientX.expectMessage(t)
+	sink.expectRecord(t, "join", "ok")
+
+	clientX.WS.WriteMessage(1, []byte("join|room="))
+	clientX.expectMessage(t)
+	sink.expectRecord(t, "join", "room name can't be empty")
+}
+
+func TestCommandAuditMetadata(t *testing.T) {
+	sink := &capturingAuditSink{records: make(chan msgSystemHub.CommandAuditRecord, 16)}
+	address := startHub(t, msgSystemHub.WithCommandAuditSink(sink))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	sink.expectRecord(t, "id", "ok")
+	sink.expectRecord(t, "id", "ok")
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=hello world"))
+	clientY.expectMessage(t)
+	record := sink.expectRecord(t, "relay", "ok")
+	if strconv.Itoa(record.ClientID) != clientX.ID || record.RemoteIP != "127.0.0.1" {
+		t.Fatalf("audit record should carry the sender connection metadata, got %+v", record)
+	}
+}
+
+func TestCommandAuditIdentity(t *testing.T) {
+	sink := &capturingAuditSink{records: make(chan msgSystemHub.CommandAuditRecord, 16)}
+	address := startHub(t, msgSystemHub.WithCommandAuditSink(sink), msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42, "guest": 0}))
+	clientX := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+
+	clientX.WS.WriteMessage(1, []byte("nick|name=alice"))
+	clientX.expectMessage(t)
+	record := sink.expectRecord(t, "nick", "ok")
+	if record.ClientID != 42 || !record.Authenticated || record.Nick != "alice" {
+		t.Fatalf("audit record should carry the authenticated user and its nickname, got %+v", record)
+	}
+
+	guest := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer guest"}})
+	guest.WS.WriteMessage(1, []byte("id"))
+	guest.expectMessage(t)
+	record = sink.expectRecord(t, "id", "ok")
+	if record.ClientID != msgSystemHub.MaxAuthenticatedUserID+1 || record.Authenticated || record.Nick != "" {
+		t.Fatalf("audit record should describe an anonymous client, got %+v", record)
+	}
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// tokenAuthenticator accepts the tokens it knows, authenticating them as the mapped user id
+type tokenAuthenticator map[string]int
+
+func (a tokenAuthenticator) Authenticate(token string) (int, error) {
+	userID, found := a[token]
+	if !found {
+		return 0, errors.New("unknown token")
+	}
+	return userID, nil
+}
+
+func TestAuthenticatedHandshake(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42, "bob": 43}))
+	clientX := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: 42" {
+		t.Fatalf("unexpected id for the authenticated client: got %q", msg)
+	}
+
+	// browser clients send the token as a query parameter
+	u := url.URL{Scheme: "ws", Host: address, Path: "/ws", RawQuery: "token=bob"}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	clientY := startTestClient(conn)
+	clientY.WS.WriteMessage(1, []byte("id"))
+	if msg := clientY.expectMessage(t); msg != "server: 43" {
+		t.Fatalf("unexpected id for the authenticated client: got %q", msg)
+	}
+}
+
+func TestRejectedHandshake(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42}))
+	dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+
+	for _, header := range []http.Header{nil, {"Authorization": []string{"Bearer mallory"}}} {
+		u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+		_, resp, err := websocket.DefaultDialer.Dial(u.String(), header)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected the handshake to be rejected with 401, got %v", err)
+		}
+	}
+}
+
+func TestAuthenticatedUserAlreadyConnected(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42}))
+	clientX := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	clientY := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+
+	clientY.expectClosing(t, "server: closing: reason=rejected reconnect=false", websocket.ClosePolicyViolation)
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: 42" {
+		t.Fatalf("the first connection should be kept: got %q", msg)
+	}
+}
+
+func TestAssignedIDsAvoidAuthenticatedUsers(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 1, "guest": 0, "huge": msgSystemHub.MaxAuthenticatedUserID + 1}))
+	alice := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	alice.WS.WriteMessage(1, []byte("id"))
+	alice.expectMessage(t)
+	alice.WS.Close()
+
+	// the guest doesn't get the id of alice, connected or not
+	guest := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer guest"}})
+	guest.WS.WriteMessage(1, []byte("id"))
+	if msg, expected := guest.expectMessage(t), fmt.Sprint("server: ", msgSystemHub.MaxAuthenticatedUserID+1); msg != expected {
+		t.Fatalf("unexpected id for the guest: expected %q, got %q", expected, msg)
+	}
+
+	// authenticated ids can't take the space of assigned ids
+	huge := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer huge"}})
+	huge.expectClosing(t, "server: closing: reason=rejected reconnect=false", websocket.ClosePolicyViolation)
+}
+
+func TestReconnectEvictsOld(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42}), msgSystemHub.WithReconnectPolicy(msgSystemHub.EvictOld))
+	alice := http.Header{"Authorization": []string{"Bearer alice"}}
+	clientX := dialTestClientWithHeader(address, alice)
+	clientX.WS.WriteMessage(1, []byte("id"))
+	clientX.expectMessage(t)
+	goroutines := runtime.NumGoroutine()
+
+	clientY := dialTestClientWithHeader(address, alice)
+	clientX.expectClosing(t, "server: closing: reason=replaced reconnect=false", websocket.ClosePolicyViolation)
+	clientY.WS.WriteMessage(1, []byte("id"))
+	if msg := clientY.expectMessage(t); msg != "server: 42" {
+		t.Fatalf("the new connection should be kept: got %q", msg)
+	}
+
+	// the goroutines of the evicted connection exit, the new connection only replaces them
+	deadline := time.Now().Add(responseTimeout)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("expected at most %d goroutines after the eviction, got %d", goroutines, n)
+	}
+	if status, clients := getClients(t, address, ""); status != http.StatusOK || fmt.Sprint(clients) != "[42]" {
+		t.Fatalf("unexpected clients: got %d %v", status, clients)
+	}
+}
+
+func TestReconnectEvictsOldWhileSending(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42}), msgSystemHub.WithReconnectPolicy(msgSystemHub.EvictOld),
+		msgSystemHub.WithWriteLatency(20*time.Millisecond, 0))
+	alice := http.Header{"Authorization": []string{"Bearer alice"}}
+	clientX := dialTestClientWithHeader(address, alice)
+
+	// the evicted connection keeps sending, its messages already read by the hub must not be answered
+	stop := make(chan struct{})
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if clientX.WS.WriteMessage(1, []byte("id")) != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-clientX.Data:
+			case <-clientX.Closed:
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	clientY := dialTestClientWithHeader(address, alice)
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-sent
+
+	clientY.WS.WriteMessage(1, []byte("id"))
+	if msg := clientY.expectMessage(t); msg != "server: 42" {
+		t.Fatalf("the new connection should be kept: got %q", msg)
+	}
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// getClients fetches the connected clients from the clients endpoint
+func getClients(t *testing.T, address, token string) (int, []int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+address+"/clients", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get clients: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Clients []int `json:"clients"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result.Clients
+}
+
+func TestGetClients(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	status, clients := getClients(t, address, "")
+	if status != http.StatusOK || fmt.Sprint(clients) != fmt.Sprintf("[%s %s]", clientX.ID, clientY.ID) {
+		t.Fatalf("unexpected clients: got %d %v", status, clients)
+	}
+}
+
+func TestGetClientsUnauthorized(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithHTTPToken("secret"))
+	newTestClient(address)
+
+	if status, _ := getClients(t, address, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
+	}
+	if status, clients := getClients(t, address, "secret"); status != http.StatusOK || len(clients) != 1 {
+		t.Fatalf("unexpected clients: got %d %v", status, clients)
+	}
+}
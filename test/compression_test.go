@@ -0,0 +1,51 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// newCompressingTestClient connects to the hub offering permessage-deflate, and tells whether the hub accepted it
+func newCompressingTestClient(t *testing.T, address string) (*TestClient, bool) {
+	t.Helper()
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial("ws://"+address+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := startTestClient(conn)
+	client.WS.WriteMessage(1, []byte("id"))
+	client.ID = strings.TrimPrefix(client.expectMessage(t), "server: ")
+	return client, strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+}
+
+func TestCompression(t *testing.T) {
+	address := startTestServer(t, msgSystemHub.WithCompression(true))
+	clientX, negotiated := newCompressingTestClient(t, address)
+	if !negotiated {
+		t.Fatal("the hub did not negotiate compression")
+	}
+	clientY, _ := newCompressingTestClient(t, address)
+
+	body := strings.Repeat("hello chaps! ", 50000)
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body="+body))
+	if msg := clientY.expectMessage(t); msg != "server: #1 "+clientX.ID+"-> "+body {
+		t.Fatalf("unexpected relayed message of %d bytes", len(msg))
+	}
+
+	// frames below the threshold are sent uncompressed
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=hello world"))
+	if msg := clientY.expectMessage(t); msg != "server: #2 "+clientX.ID+"-> hello world" {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+}
+
+func TestCompressionDisabledByDefault(t *testing.T) {
+	address := startTestServer(t)
+	if _, negotiated := newCompressingTestClient(t, address); negotiated {
+		t.Fatal("the hub negotiated compression without WithCompression")
+	}
+}
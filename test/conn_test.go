@@ -0,0 +1,93 @@
+package test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	msgSystemClient "github.com/jpaldi/golang-simplified-message-system/client"
+)
+
+// dialConn connects a client.Conn to the hub, it is closed when the test ends
+func dialConn(t *testing.T, address string) (*msgSystemClient.Conn, int) {
+	t.Helper()
+	conn, err := msgSystemClient.Dial("ws://" + address + "/ws")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	id, err := conn.ID()
+	if err != nil {
+		t.Fatalf("id: %v", err)
+	}
+	return conn, id
+}
+
+// expectConnMessage waits for the next message relayed to conn
+func expectConnMessage(t *testing.T, conn *msgSystemClient.Conn) msgSystemClient.Message {
+	t.Helper()
+	select {
+	case msg := <-conn.Messages():
+		return msg
+	case <-time.After(responseTimeout):
+		t.Fatal("the connection did not receive any message")
+		return msgSystemClient.Message{}
+	}
+}
+
+func TestConn(t *testing.T) {
+	address := startTestServer(t)
+	connX, idX := dialConn(t, address)
+	connY, idY := dialConn(t, address)
+
+	users, err := connX.List()
+	if err != nil || !reflect.DeepEqual(users, []int{idY}) {
+		t.Fatalf("unexpected users list: got %v, %v", users, err)
+	}
+
+	if err := connX.Relay([]int{idY}, []byte("hello, chaps!")); err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	msg := expectConnMessage(t, connY)
+	if msg.From != idX || string(msg.Body) != "hello, chaps!" || msg.Sent.IsZero() {
+		t.Fatalf("unexpected relayed message: got %+v", msg)
+	}
+
+	// the relay is still delivered to the users that are connected
+	if err := connX.Relay([]int{idY, 999999}, []byte("hello again")); err == nil {
+		t.Fatal("relay to an unknown user did not fail")
+	}
+	if msg := expectConnMessage(t, connY); string(msg.Body) != "hello again" {
+		t.Fatalf("unexpected relayed message: got %+v", msg)
+	}
+
+	err = connX.Relay(nil, []byte("hello nobody"))
+	if hubErr, ok := err.(*msgSystemClient.Error); !ok || hubErr.Code != "bad_relay_format" {
+		t.Fatalf("unexpected error relaying to nobody: got %v", err)
+	}
+
+	// requests keep working alongside the messages sent to the connection
+	if err := connY.Relay([]int{idX}, []byte(fmt.Sprintf("hello %d", idX))); err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	if id, err := connX.ID(); err != nil || id != idX {
+		t.Fatalf("unexpected id: got %d, %v", id, err)
+	}
+	if msg := expectConnMessage(t, connX); msg.From != idY {
+		t.Fatalf("unexpected relayed message: got %+v", msg)
+	}
+}
+
+func TestConnClosed(t *testing.T) {
+	address := startTestServer(t)
+	conn, _ := dialConn(t, address)
+
+	conn.Close()
+	if _, ok := <-conn.Messages(); ok {
+		t.Fatal("messages were not closed with the connection")
+	}
+	if _, err := conn.List(); err == nil {
+		t.Fatal("list on a closed connection did not fail")
+	}
+}
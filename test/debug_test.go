@@ -0,0 +1,64 @@
+package test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestHeaders(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithDebug(true))
+	clientX := dialTestClientWithHeader(address, http.Header{
+		"X-Forwarded-For": []string{"10.0.0.1"},
+		"Authorization":   []string{"Bearer secret"},
+	})
+
+	clientX.WS.WriteMessage(1, []byte("headers"))
+	msg := clientX.expectMessage(t)
+	if !strings.HasPrefix(msg, "server: headers: \n") || !strings.Contains(msg, "X-Forwarded-For: 10.0.0.1\n") {
+		t.Fatalf("unexpected response from server: expected the X-Forwarded-For header, got %q", msg)
+	}
+	if strings.Contains(msg, "secret") {
+		t.Fatalf("the Authorization header should not be echoed back, got %q", msg)
+	}
+}
+
+func TestHeadersRequiresDebug(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("headers"))
+	if msg := clientX.expectMessage(t); msg != "server: debug commands are disabled" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestParse(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithDebug(true))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("parse|relay|users="+clientY.ID+";"+unknownUserID+",body=hi,dryrun=true"))
+	want := `server: parsed: {"command":"relay","users":["` + clientY.ID + `","` + unknownUserID + `"],"body":"hi","dryrun":true}`
+	if msg := clientX.expectMessage(t); msg != want {
+		t.Fatalf("unexpected response from server: got %q, want %q", msg, want)
+	}
+	clientY.expectNoMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte("parse|relay|users="+clientY.ID))
+	if msg := clientX.expectMessage(t); msg != "server: relay message should contain users and body fields" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestParseRequiresDebug(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("parse|id"))
+	if msg := clientX.expectMessage(t); msg != "server: debug commands are disabled" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
@@ -1,11 +1,19 @@
 package test
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,135 +21,966 @@ import (
 	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
 )
 
+const (
+	responseTimeout = time.Second * 1 // give some breathing room to receive the server response
+	unknownUserID   = "999999"        // unknownUserID is never assigned to a test client
+)
+
 func TestGetID(t *testing.T) {
-	var clientX *TestClient
-	address := "localhost:8080"
-	go msgSystemHub.InitHub(address)
-	clientX = newTestClient(address)
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("id"))
+	msg := clientX.expectMessage(t)
+	if !strings.HasPrefix(msg, "server: ") {
+		t.Fatalf("unexpected response from server: expected to be prefixed by 'server: ', got %s", msg)
+	}
+	if _, err := strconv.Atoi(strings.TrimPrefix(msg, "server: ")); err != nil {
+		t.Fatalf("unexpected response from server: user id expected to be a number, got %s, err: %v", msg, err)
+	}
+}
+
+func TestAssignedIDs(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientY.WS.Close()
+	clientZ := newTestClient(address)
+
+	// ids are assigned in connection order and never reused
+	for expected, c := range map[string]*TestClient{"1": clientX, "2": clientY, "3": clientZ} {
+		if c.ID != expected {
+			t.Fatalf("unexpected user id: expected %s, got %s", expected, c.ID)
+		}
+	}
+}
+
+func TestIPv6ClientsGetTheirOwnIDs(t *testing.T) {
+	// clients connecting from an IPv6 remote address such as [::1]:40000 are assigned ids like any other
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback is not available")
+	}
+	hub := msgSystemHub.NewHub("")
+	server := httptest.NewUnstartedServer(hub.Router())
+	server.Listener.Close()
+	server.Listener = l
+	server.Start()
+	hub.Start()
+	t.Cleanup(server.Close)
+	t.Cleanup(hub.Close)
+	address := l.Addr().String()
+
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	for expected, c := range map[string]*TestClient{"1": clientX, "2": clientY} {
+		if c.ID != expected {
+			t.Fatalf("unexpected user id: expected %s, got %s", expected, c.ID)
+		}
+	}
 
-	go clientX.assertGetIDResponseFromServer(t)
-	go clientX.WS.WriteMessage(1, []byte("id"))
-	time.Sleep(time.Second * 1) // give some breathing room to receive the server response
-	clientX = nil
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello world", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
 }
 
 func TestGetList(t *testing.T) {
-	var clientX *TestClient
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address) // create another client, otherwise only the client X will be connected and list will be empty
+
+	clientX.WS.WriteMessage(1, []byte("list"))
+	msg := clientX.expectMessage(t)
+	if !strings.HasPrefix(msg, "server: ") {
+		t.Fatalf("unexpected response from server: expected to be prefixed by 'server: ', got %s", msg)
+	}
+
+	portString := strings.TrimPrefix(msg, "server: users list: \n0) ")
+	portString = strings.TrimSuffix(portString, "\n")
+	if portString != clientY.ID {
+		t.Fatalf("unexpected response from server: expected list with user %s, got %s", clientY.ID, msg)
+	}
+}
+
+func TestListWhileClientsConnect(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	// clients keep connecting and disconnecting while clientX lists them, run with -race
+	u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+	stop := make(chan struct{})
+	var churning sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		churning.Add(1)
+		go func() {
+			defer churning.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil); err == nil {
+					conn.Close()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		clientX.WS.WriteMessage(1, []byte("list"))
+		if msg := clientX.expectMessage(t); !strings.HasPrefix(msg, "server: users list: \n") {
+			t.Fatalf("unexpected response from server: got %q", msg)
+		}
+	}
+	close(stop)
+	churning.Wait()
+}
 
-	address := "localhost:8081"
-	go msgSystemHub.InitHub(address)
-	clientX = newTestClient(address)
-	_ = newTestClient(address) // create another client, otherwise only the client X will be connected and list will be empty
+func TestGetListPage(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	others := make([]*TestClient, 5)
+	for i := range others {
+		others[i] = newTestClient(address)
+	}
+
+	// others are listed in connection order
+	clientX.WS.WriteMessage(1, []byte("list|offset=3,limit=2,sort=connected"))
+	expected := fmt.Sprintf("server: users list: total=5\n3) %s\n4) %s\n", others[3].ID, others[4].ID)
+	if msg := clientX.expectMessage(t); msg != expected {
+		t.Fatalf("unexpected users list page: expected %q, got %q", expected, msg)
+	}
+
+	// a page past the end is empty but still reports the total
+	clientX.WS.WriteMessage(1, []byte("list|offset=5,limit=2"))
+	if msg := clientX.expectMessage(t); msg != "server: users list: total=5\n" {
+		t.Fatalf("unexpected users list page: got %q", msg)
+	}
 
-	go clientX.assertGetListResponseFromServer(t)
-	go clientX.WS.WriteMessage(1, []byte("list"))
-	time.Sleep(time.Second * 1) // give some breathing room to receive the server response
+	ids := make([]int, len(others))
+	for i, c := range others {
+		ids[i], _ = strconv.Atoi(c.ID)
+	}
+	sort.Ints(ids)
+	clientX.WS.WriteMessage(1, []byte("list|offset=0,limit=2,sort=id"))
+	expected = fmt.Sprintf("server: users list: total=5\n0) %d\n1) %d\n", ids[0], ids[1])
+	if msg := clientX.expectMessage(t); msg != expected {
+		t.Fatalf("unexpected users list page: expected %q, got %q", expected, msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("list|limit=0"))
+	if msg := clientX.expectMessage(t); msg != "server: list limit should be between 1 and 1000, got 0" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
 }
 
 func TestRelay(t *testing.T) {
-	testChannel := make(chan string)
-	address := "localhost:8082"
-	go msgSystemHub.InitHub(address)
+	address := startHub(t)
 	clientX := newTestClient(address)
-	_ = newTestClient(address) // create another client, otherwise only the client X will be connected and list will be empty
+	clientY := newTestClient(address)
 
-	go clientX.assertRelayMessage(t, testChannel)
-	go clientX.getUserIDsAndAttachRelay(testChannel)
-	clientX.WS.WriteMessage(1, []byte("list"))
-	time.Sleep(time.Second * 1) // give some breathing room to receive the server response
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	msg := clientY.expectMessage(t)
+	if expected := fmt.Sprintf("server: #1 %s-> hello world", clientX.ID); msg != expected {
+		t.Fatalf("unexpected relayed message: expected %q, got %q", expected, msg)
+	}
+	// the server does not respond to the user when it sends relay messages
+	clientX.expectNoMessage(t)
+}
+
+func TestMessageIDs(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=first"))
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=second,dryrun=true"))
+	clientX.WS.WriteMessage(1, []byte("broadcast|body=third"))
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=fourth"))
+
+	// every delivery starts with the id the hub assigned to the message, dry runs don't take one
+	lastID := 0
+	for _, body := range []string{"first", "third", "fourth"} {
+		var id int
+		msg := clientY.expectMessage(t)
+		if _, err := fmt.Sscanf(msg, "server: #%d", &id); err != nil || id <= lastID || msg != fmt.Sprintf("server: #%d %s-> %s", id, clientX.ID, body) {
+			t.Fatalf("expected %s with an id above %d, got %q", body, lastID, msg)
+		}
+		lastID = id
+	}
+}
 
+func TestRelayBinary(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	body := []byte{0xff, 0x00, ',', 0xfe, 'h', 'i'}
+	clientX.WS.WriteMessage(websocket.BinaryMessage, append([]byte("relay|users="+clientY.ID+",body="), body...))
+	select {
+	case msg := <-clientY.Binary:
+		if !bytes.Equal(msg, body) {
+			t.Fatalf("unexpected binary message: got %v, want %v", msg, body)
+		}
+	case msg := <-clientY.Data:
+		t.Fatalf("expected a binary message, got text %q", msg)
+	case <-time.After(responseTimeout):
+		t.Fatal("client did not receive the binary message")
+	}
+
+	// the body is everything after body=, so the id of the relay comes before it
+	clientX.WS.WriteMessage(websocket.BinaryMessage, append([]byte("relay|users="+clientY.ID+",id=abc,body="), body...))
+	if msg := clientX.expectMessage(t); msg != "server: ack: id=abc delivered="+clientY.ID+" failed=" {
+		t.Fatalf("unexpected ack: got %q", msg)
+	}
+	if msg := <-clientY.Binary; !bytes.Equal(msg, body) {
+		t.Fatalf("unexpected binary message: got %v, want %v", msg, body)
+	}
+
+	// replies to binary messages stay text
+	clientX.WS.WriteMessage(websocket.BinaryMessage, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+		t.Fatalf("unexpected id: got %q", msg)
+	}
+}
+
+func TestRelaySkipsSender(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", clientX.ID, clientY.ID)))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello world", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestRelaySelfEcho(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithSelfEcho(true))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", clientX.ID, clientY.ID)))
+	expected := fmt.Sprintf("server: #1 %s-> hello world", clientX.ID)
+	for _, c := range []*TestClient{clientX, clientY} {
+		if msg := c.expectMessage(t); msg != expected {
+			t.Fatalf("unexpected relayed message: expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+func TestRelayReceiversOverflowRejected(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", clientY.ID, unknownUsers(255))))
+	if msg := clientX.expectMessage(t); msg != "server: max receivers per message exceeded" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+}
+
+func TestRelayReceiversOverflowTruncated(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithReceiverOverflowPolicy(msgSystemHub.TruncateWithWarning))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientZ := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s;%s,body=hello world", clientY.ID, unknownUsers(254), clientZ.ID)))
+	if msg := clientX.expectMessage(t); !strings.HasPrefix(msg, "server: max receivers per message exceeded, delivering to the first 255 users") {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectMessage(t)
+	clientZ.expectNoMessage(t)
+}
+
+func TestRelayUsersFieldTooLong(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	clientX.WS.WriteMessage(1, []byte("relay|users="+strings.Repeat(";", 1000000)+",body=hello world"))
+	if msg := clientX.expectMessage(t); msg != "server: relay users field can't exceed 8192 bytes" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	runtime.ReadMemStats(&after)
+
+	// splitting a million separators alone would allocate 16MB of string headers
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 12<<20 {
+		t.Fatalf("rejecting the relay allocated %d bytes", allocated)
+	}
+}
+
+func TestConfigurableLimits(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMaxBodySize(10), msgSystemHub.WithMaxReceivers(2))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hi", clientY.ID, unknownUsers(2))))
+	if msg := clientX.expectMessage(t); msg != "server: max receivers per message exceeded" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello chaps", clientY.ID)))
+	if msg := clientX.expectMessage(t); msg != "server: message body can't exceed 10 bytes" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+
+	// a frame too large to hold a valid relay is refused by the transport
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body="+strings.Repeat("a", 10000)))
+	clientX.expectClose(t, websocket.CloseMessageTooBig)
+}
+
+func TestInvalidLimitsUseDefaults(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMetricsInterval(0), msgSystemHub.WithPingInterval(0),
+		msgSystemHub.WithDisconnectBuffer(-1), msgSystemHub.WithSendBuffer(0))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello", clientY.ID)))
+	if expected := fmt.Sprintf("server: #1 %s-> hello", clientX.ID); clientY.expectMessage(t) != expected {
+		t.Fatalf("unexpected relayed message: expected %q", expected)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMaxClients(2))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+	_, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the handshake to be rejected with 503, got %v", err)
+	}
+
+	clientY.WS.Close()
+	clientX.expectPresence(t) // the join event of clientY
+	clientX.expectPresence(t) // the leave event frees a slot
+	clientZ := dialTestClient(address)
+	clientZ.WS.WriteMessage(1, []byte("id"))
+	if msg := clientZ.expectMessage(t); strings.HasPrefix(msg, "server: closing:") {
+		t.Fatalf("expected a client to connect once a slot is free, got %q", msg)
+	}
+}
+
+func TestRelayWriteLatency(t *testing.T) {
+	latency := time.Millisecond * 200
+	address := startHub(t, msgSystemHub.WithWriteLatency(latency, time.Millisecond*50))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	start := time.Now()
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	clientY.expectMessage(t)
+	if elapsed := time.Since(start); elapsed < latency || elapsed > latency*3 {
+		t.Fatalf("expected delivery to be delayed by about %v, took %v", latency, elapsed)
+	}
+}
+
+func TestUnknownCommandsDisconnect(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithUnknownCommandLimit(3, time.Second))
+	clientX := newTestClient(address)
+
+	for i := 0; i < 2; i++ {
+		clientX.WS.WriteMessage(1, []byte("hello"))
+		if msg := clientX.expectMessage(t); msg != "server: command not recognized" {
+			t.Fatalf("unexpected response from server: got %q", msg)
+		}
+	}
+	clientX.WS.WriteMessage(1, []byte("hello"))
+	clientX.expectClosing(t, "server: closing: reason=too_many_unknown_commands reconnect=false", websocket.ClosePolicyViolation)
+}
+
+func TestRelayByteQuota(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithByteQuota(20, 0))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientZ := newTestClient(address)
+
+	// a single relay to both clients would cross the 20 bytes quota
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", clientY.ID, clientZ.ID)))
+	if msg := clientX.expectMessage(t); msg != "server: byte quota exceeded" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+
+	relay := []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID))
+	clientX.WS.WriteMessage(1, relay)
+	clientY.expectMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"relay","users":[`+clientY.ID+`],"body":"hello world"}`))
+	if msg := clientX.expectMessage(t); msg != `{"type":"error","code":"quota_exceeded","detail":"byte quota exceeded"}` {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+}
+
+func TestRelayPrefixFunc(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRelayPrefixFunc(func(senderID, recipientID int) string {
+		return fmt.Sprintf("%d to %d: ", senderID, recipientID)
+	}))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", clientY.ID, clientZ.ID)))
+	for _, recipient := range []*TestClient{clientY, clientZ} {
+		expected := fmt.Sprintf("server: #1 %s to %s: hello world", clientX.ID, recipient.ID)
+		if msg := recipient.expectMessage(t); msg != expected {
+			t.Fatalf("unexpected relayed message: expected %q, got %q", expected, msg)
+		}
+	}
+}
+
+func TestRelayDryRun(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s;%s,body=hello world,dryrun=true", clientY.ID, clientZ.ID, unknownUserID)))
+	if msg := clientX.expectMessage(t); msg != "server: userid not found: "+unknownUserID {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	if msg := clientX.expectMessage(t); msg != "server: dry run: relay would be delivered to 2 users" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+	clientZ.expectNoMessage(t)
+}
+
+func TestRelayUsersValidated(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	// duplicates, whitespace around ids and a trailing separator deliver a single copy to each user
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s; %s ;%s;,body=hello world", clientY.ID, clientZ.ID, clientY.ID)))
+	for _, c := range []*TestClient{clientY, clientZ} {
+		if msg := c.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello world", clientX.ID) {
+			t.Fatalf("unexpected relayed message: got %q", msg)
+		}
+		c.expectNoMessage(t)
+	}
+
+	for users, want := range map[string]string{
+		clientY.ID + ";bob": "server: invalid user id in relay users: bob",
+		";" + clientY.ID:    "server: relay users can't contain an empty id",
+	} {
+		clientX.WS.WriteMessage(1, []byte("relay|users="+users+",body=hello world"))
+		if msg := clientX.expectMessage(t); msg != want {
+			t.Fatalf("unexpected response to users=%s: got %q, want %q", users, msg, want)
+		}
+	}
+	clientY.expectNoMessage(t)
+}
+
+func TestRelayEmptyBody(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	for _, c := range []*TestClient{clientX, clientY} {
+		c.WS.WriteMessage(1, []byte("join|room=foo"))
+		c.expectMessage(t)
+	}
+
+	for _, body := range []string{"", " \t "} {
+		for _, command := range []string{"relay|users=" + clientY.ID + ",body=", "broadcast|body=", "send|room=foo,body="} {
+			clientX.WS.WriteMessage(1, []byte(command+body))
+			if msg := clientX.expectMessage(t); msg != "server: message body can't be empty" {
+				t.Fatalf("unexpected response to %q: got %q", command+body, msg)
+			}
+		}
+	}
+	clientY.expectNoMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf(`{"type":"relay","users":[%s],"body":" "}`, clientY.ID)))
+	if msg := clientX.expectMessage(t); msg != `{"type":"error","code":"bad_relay_format","detail":"message body can't be empty"}` {
+		t.Fatalf("unexpected response to an empty JSON relay: got %q", msg)
+	}
+}
+
+func TestRelayAllowEmptyBody(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAllowEmptyBody(true))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	for i, body := range []string{"", " "} {
+		clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body="+body))
+		if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #%d %s-> %s", i+1, clientX.ID, body) {
+			t.Fatalf("unexpected relayed message: got %q", msg)
+		}
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestRelayVerboseErrors(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRelayErrorVerbosity(msgSystemHub.Verbose))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello world", unknownUserID, clientY.ID)))
+	if msg := clientX.expectMessage(t); msg != "server: relay failed: message=1 recipient="+unknownUserID+" reason=user_not_found" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectMessage(t)
+}
+
+func TestRelayTerseErrors(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+unknownUserID+",body=hello world"))
+	if msg := clientX.expectMessage(t); msg != "server: userid not found: "+unknownUserID {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestMassDisconnectDuringRelay(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithDisconnectBuffer(8))
+	clientX := newTestClient(address)
+	recipients := make([]*TestClient, 50)
+	ids := make([]string, len(recipients))
+	for i := range recipients {
+		recipients[i] = newTestClient(address)
+		ids[i] = recipients[i].ID
+	}
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=%s", strings.Join(ids, ";"), strings.Repeat("a", 500000))))
+	for _, c := range recipients {
+		go c.WS.Close()
+	}
+
+	// the hub keeps serving clients and eventually drops every disconnected one
+	clientY := newTestClient(address)
+	deadline := time.Now().Add(responseTimeout * 2)
+	for {
+		clientY.WS.WriteMessage(1, []byte("list|limit=1000"))
+		msg := clientY.expectMessage(t)
+		if strings.HasPrefix(msg, "server: users list: total=1\n") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("disconnected clients are still listed: %q", msg)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestRelayFallback(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	// the primary is online, the fallback isn't used
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s|fallback=%s,body=hello world", clientY.ID, clientZ.ID)))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello world", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+	clientZ.expectNoMessage(t)
+
+	// the primary is offline, the message goes to the fallback
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s|fallback=%s,body=hello world", unknownUserID, clientZ.ID)))
+	if msg := clientZ.expectMessage(t); msg != fmt.Sprintf("server: #2 %s-> hello world", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+	clientX.expectNoMessage(t)
+
+	// both are offline
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s|fallback=%s,body=hello world", unknownUserID, unknownUserID)))
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf("server: userid not found: %s|fallback=%s", unknownUserID, unknownUserID) {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestSlowClientDoesNotBlockOthers(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithSendBuffer(4))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	slowClient := newTestClient(address) // the test never reads its messages
+	go func() {
+		for range clientX.Data { // X receives errors once the slow client is dropped
+		}
+	}()
+
+	relay := []byte(fmt.Sprintf("relay|users=%s;%s,body=%s", slowClient.ID, clientY.ID, strings.Repeat("a", 200000)))
+	for i := 0; i < 60; i++ {
+		clientX.WS.WriteMessage(1, relay)
+		clientY.expectMessage(t)
+	}
+}
+
+func TestMOTD(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMOTD("welcome to the hub"))
+	clientX := dialTestClient(address)
+
+	if msg := clientX.expectMessage(t); msg != "server: motd: welcome to the hub" {
+		t.Fatalf("unexpected message of the day: got %q", msg)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	address := freeAddress()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- msgSystemHub.NewHub(address).Run(ctx)
+	}()
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	cancel()
+	clientX.expectClosing(t, "server: closing: reason=shutting_down reconnect=true", websocket.CloseGoingAway)
+	clientY.expectClosing(t, "server: closing: reason=shutting_down reconnect=true", websocket.CloseGoingAway)
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("unexpected error stopping the hub: %v", err)
+		}
+	case <-time.After(responseTimeout):
+		t.Fatal("hub did not stop")
+	}
+}
+
+func TestUnresponsiveClientDisconnected(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithPingInterval(responseTimeout/10))
+	clientX := newTestClient(address)
+
+	// clientY reads messages but never answers pings, like a client whose network dropped
+	u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetPingHandler(func(string) error { return nil })
+	clientY := startTestClient(conn)
+
+	clientY.expectClose(t, websocket.CloseAbnormalClosure)
+	// the hub may handle the list before the disconnect it already has queued
+	for retries := 0; ; retries++ {
+		clientX.WS.WriteMessage(1, []byte("list"))
+		msg := clientX.expectMessage(t)
+		if msg == "server: users list: \n" {
+			break
+		}
+		if retries == 10 {
+			t.Fatalf("unresponsive client still listed: got %q", msg)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	idleTimeout := responseTimeout / 5
+	address := startHub(t, msgSystemHub.WithIdleTimeout(idleTimeout))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	// clientY keeps sending messages for twice the idle timeout while clientX sends nothing
+	for start := time.Now(); time.Since(start) < 2*idleTimeout; {
+		clientY.WS.WriteMessage(1, []byte("id"))
+		clientY.expectMessage(t)
+		time.Sleep(idleTimeout / 4)
+	}
+	clientX.expectClosing(t, "server: closing: reason=idle_timeout reconnect=true", websocket.CloseNormalClosure)
+
+	clientY.WS.WriteMessage(1, []byte("id"))
+	if msg := clientY.expectMessage(t); msg != "server: "+clientY.ID {
+		t.Fatalf("the active client should be kept: got %q", msg)
+	}
+}
+
+func TestIdleTimeoutStalledClient(t *testing.T) {
+	idleTimeout := responseTimeout / 5
+	address := startHub(t, msgSystemHub.WithIdleTimeout(idleTimeout))
+	clientY := newTestClient(address)
+	stalled, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stalled.Close()
+
+	// the stalled client never reads, its write goroutine blocks once the socket buffers are full
+	body := strings.Repeat("a", 500000)
+	for i := 0; i < 60; i++ {
+		clientY.WS.WriteMessage(1, []byte("broadcast|body="+body))
+		clientY.expectMessage(t)
+	}
+	time.Sleep(2 * idleTimeout)
+
+	// closing the idle stalled client must not stall the hub
+	clientZ := dialTestClient(address)
+	clientZ.WS.WriteMessage(1, []byte("id"))
+	if msg := clientZ.expectMessage(t); !strings.HasPrefix(msg, "server: ") {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestIdleTimeoutCountsPongs(t *testing.T) {
+	idleTimeout := responseTimeout / 5
+	address := startHub(t, msgSystemHub.WithIdleTimeout(idleTimeout), msgSystemHub.WithIdlePongs(true), msgSystemHub.WithPingInterval(idleTimeout/4))
+	clientX := newTestClient(address)
+
+	time.Sleep(2 * idleTimeout)
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+		t.Fatalf("a client answering pings should be kept: got %q", msg)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("broadcast|body=hello, chaps!"))
+	if msg := clientX.expectMessage(t); msg != "server: broadcast delivered to 2 clients" {
+		t.Fatalf("unexpected broadcast report: got %q", msg)
+	}
+	for _, c := range []*TestClient{clientY, clientZ} {
+		if msg := c.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello, chaps!", clientX.ID) {
+			t.Fatalf("unexpected broadcast message: got %q", msg)
+		}
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestBroadcastBodyTooLarge(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("broadcast|body="+strings.Repeat("a", 1024001)))
+	if msg := clientX.expectMessage(t); msg != "server: message body can't exceed 1024kb" {
+		t.Fatalf("unexpected broadcast error: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+}
+
+func TestShutdownClosesUnregisteredClients(t *testing.T) {
+	// the interceptor keeps the hub goroutine busy, so clients connecting meanwhile can't be registered
+	blocked, unblock := make(chan struct{}), make(chan struct{})
+	block := func(senderID int, body []byte) ([]byte, bool) {
+		close(blocked)
+		<-unblock
+		return body, true
+	}
+	address := freeAddress()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- msgSystemHub.NewHub(address, msgSystemHub.WithInterceptors(block)).Run(ctx)
+	}()
+	clientX := newTestClient(address)
+	clientX.WS.WriteMessage(1, []byte("broadcast|body=hello"))
+	<-blocked
+
+	clientY := dialTestClient(address)
+	cancel()
+	clientY.expectClose(t, websocket.CloseGoingAway)
+	close(unblock)
+	clientX.expectClose(t, websocket.CloseGoingAway)
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("unexpected error stopping the hub: %v", err)
+		}
+	case <-time.After(responseTimeout):
+		t.Fatal("hub did not stop")
+	}
+}
+
+// unknownUsers lists n distinct user ids that are never assigned to a test client
+func unknownUsers(n int) string {
+	users := make([]string, n)
+	for i := range users {
+		users[i] = fmt.Sprint(1000000 + i)
+	}
+	return strings.Join(users, ";")
+}
+
+// startHub runs a hub on a free local address and returns that address, the hub is shut down when the test ends
+func startHub(t *testing.T, opts ...msgSystemHub.Option) string {
+	address := freeAddress()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		msgSystemHub.NewHub(address, opts...).Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return address
+}
+
+// freeAddress returns a local address no one is listening on
+func freeAddress() string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		log.Fatal("listen:", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
 }
 
 type TestClient struct {
-	WS   *websocket.Conn
-	Data chan []byte
+	ID       string
+	WS       *websocket.Conn
+	Data     chan []byte // Data receives the text messages sent to the client
+	Binary   chan []byte // Binary receives the binary messages sent to the client
+	Presence chan []byte // Presence keeps the presence events sent to the client, apart from its other messages
+	Closed   chan error  // Closed receives the error that stopped the read loop
+}
+
+// startTestClient starts reading the messages sent on conn
+func startTestClient(conn *websocket.Conn) *TestClient {
+	client := &TestClient{WS: conn, Data: make(chan []byte), Binary: make(chan []byte), Presence: make(chan []byte, 64), Closed: make(chan error, 1)}
+	go client.read()
+	return client
 }
 
+// newTestClient connects to the hub and waits until the hub has registered it, storing its user id
 func newTestClient(address string) *TestClient {
+	client := dialTestClient(address)
+	client.WS.WriteMessage(1, []byte("id"))
+	select {
+	case msg := <-client.Data:
+		client.ID = strings.TrimPrefix(string(msg), "server: ")
+	case <-time.After(responseTimeout):
+		log.Fatal("no id received from the hub")
+	}
+	return client
+}
+
+// dialTestClient connects to the hub without waiting for the hub to register the client
+func dialTestClient(address string) *TestClient {
+	return dialTestClientWithHeader(address, nil)
+}
+
+// dialTestClientWithHeader connects to the hub sending the given handshake headers
+func dialTestClientWithHeader(address string, header http.Header) *TestClient {
 	u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
 	log.Printf("connecting to %s", u.String())
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+
+	var c *websocket.Conn
+	var err error
+	for retries := 0; retries < 50; retries++ { // the hub may still be starting up
+		c, _, err = websocket.DefaultDialer.Dial(u.String(), header)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
 	if err != nil {
 		log.Fatal("dial:", err)
 	}
 
-	client := &TestClient{WS: c, Data: make(chan []byte)}
-	go client.read()
-
+	client := startTestClient(c)
 	return client
+}
 
+// expectMessage waits for the next message sent to the client, failing the test if none arrives
+func (c *TestClient) expectMessage(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-c.Data:
+		return string(msg)
+	case <-time.After(responseTimeout):
+		t.Fatalf("client %s did not receive any message", c.ID)
+		return ""
+	}
 }
 
-func (c *TestClient) getUserIDsAndAttachRelay(listUserIDChannel chan string) {
-	for {
-		select {
-		case msg := <-listUserIDChannel:
-			relayMsg := fmt.Sprintf("relay|users=%s,body=hello world", msg)
-			c.WS.WriteMessage(1, []byte(relayMsg))
-		}
+// expectPresence waits for the next presence event sent to the client, failing the test if none arrives
+func (c *TestClient) expectPresence(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-c.Presence:
+		return string(msg)
+	case <-time.After(responseTimeout):
+		t.Fatalf("client %s did not receive any presence event", c.ID)
+		return ""
 	}
 }
 
-func (c *TestClient) assertRelayMessage(t *testing.T, listUserIDChannel chan string) {
-	for {
-		select {
-		case msg := <-c.Data:
-			if strings.HasPrefix(string(msg), "server: users list:") {
-				portString := strings.TrimPrefix(string(msg), "server: users list: \n0) ")
-				portString = strings.TrimSuffix(portString, "\n")
-				_, err := strconv.Atoi(portString)
-				if err != nil {
-					t.Fatalf("unexpected response from server: user id expected to be a number, got %s, err: %v", string(msg), err)
-				}
-				listUserIDChannel <- portString
-			} else {
-				// the server does not respond to  user when he sends relay messages
-				t.Fatal(msg)
-			}
-		}
+// expectNoMessage fails the test if the client receives a message in the next moments
+func (c *TestClient) expectNoMessage(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-c.Data:
+		t.Fatalf("client %s received unexpected message: %s", c.ID, string(msg))
+	case <-time.After(responseTimeout / 4):
 	}
 }
 
-func (c *TestClient) assertGetIDResponseFromServer(t *testing.T) {
+// expectClose discards incoming messages until the hub closes the connection with the given close code
+func (c *TestClient) expectClose(t *testing.T, code int) {
+	t.Helper()
+	timeout := time.After(responseTimeout)
 	for {
 		select {
-		case msg := <-c.Data:
-			if !strings.HasPrefix(string(msg), "server: ") {
-				t.Fatalf("unexpected response from server: expected to be prefixed by 'server: ', got %s", string(msg))
+		case <-c.Data:
+		case err := <-c.Closed:
+			if !websocket.IsCloseError(err, code) {
+				t.Fatalf("client %s expected to be closed with code %d, got %v", c.ID, code, err)
 			}
-
+			return
+		case <-timeout:
+			t.Fatalf("client %s was not disconnected", c.ID)
 		}
-
 	}
 }
 
-func (c *TestClient) assertGetListResponseFromServer(t *testing.T) {
+// expectClosing discards incoming messages until the given closing frame, which must arrive before the hub
+// closes the connection with the given close code
+func (c *TestClient) expectClosing(t *testing.T, frame string, code int) {
+	t.Helper()
+	timeout := time.After(responseTimeout)
 	for {
 		select {
 		case msg := <-c.Data:
-			if !strings.HasPrefix(string(msg), "server: ") {
-				t.Fatalf("unexpected response from server: expected to be prefixed by 'server: ', got %s", string(msg))
-			}
-
-			portString := strings.TrimPrefix(string(msg), "server: users list: \n0) ")
-			portString = strings.TrimSuffix(portString, "\n")
-			_, err := strconv.Atoi(portString)
-			if err != nil {
-				t.Fatalf("unexpected response from server: user id expected to be a number, got %s, err: %v", string(msg), err)
+			if string(msg) == frame {
+				c.expectClose(t, code)
+				return
 			}
+		case err := <-c.Closed:
+			t.Fatalf("client %s was closed before receiving %q: %v", c.ID, frame, err)
+		case <-timeout:
+			t.Fatalf("client %s did not receive %q", c.ID, frame)
 		}
 	}
 }
 
 func (c *TestClient) read() {
 	for {
-		_, msg, err := c.WS.ReadMessage()
-		fmt.Println(string(msg))
+		messageType, msg, err := c.WS.ReadMessage()
 		if err != nil {
 			c.WS.Close()
+			c.Closed <- err
 			return
 		}
-		if len(msg) > 0 {
+		if messageType == websocket.BinaryMessage {
+			c.Binary <- msg
+		} else if bytes.HasPrefix(msg, []byte("server: presence: ")) || bytes.HasPrefix(msg, []byte(`{"type":"presence"`)) {
+			select {
+			case c.Presence <- msg:
+			default: // tests that don't look at presence events may get many of them
+			}
+		} else if len(msg) > 0 {
 			c.Data <- msg
 		}
 	}
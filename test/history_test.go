@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestHistory(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	for _, body := range []string{"first", "second"} {
+		clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=%s", clientY.ID, body)))
+		clientY.expectMessage(t)
+	}
+
+	clientY.WS.WriteMessage(1, []byte("history|count=1"))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: history: \n#2 %s-> second\n", clientX.ID) {
+		t.Fatalf("unexpected history: got %q", msg)
+	}
+
+	// asking for more messages than stored returns all of them
+	clientY.WS.WriteMessage(1, []byte("history|count=10"))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: history: \n#1 %[1]s-> first\n#2 %[1]s-> second\n", clientX.ID) {
+		t.Fatalf("unexpected history: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("history|count=10"))
+	if msg := clientX.expectMessage(t); msg != "server: history: \n" {
+		t.Fatalf("expected an empty history: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("history|count=0"))
+	if msg := clientX.expectMessage(t); msg != "server: history count should be a positive number, got 0" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestHistoryEviction(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithHistoryCapacity(3))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	for i := 1; i <= 5; i++ {
+		clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=%d", clientY.ID, i)))
+		clientY.expectMessage(t)
+	}
+
+	clientY.WS.WriteMessage(1, []byte("history|count=5"))
+	want := fmt.Sprintf("server: history: \n#3 %[1]s-> 3\n#4 %[1]s-> 4\n#5 %[1]s-> 5\n", clientX.ID)
+	if msg := clientY.expectMessage(t); msg != want {
+		t.Fatalf("the oldest messages should be evicted: got %q, want %q", msg, want)
+	}
+}
+
+func TestHistorySurvivesReconnection(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42, "bob": 43}))
+	clientX := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer bob"}})
+	alice := http.Header{"Authorization": []string{"Bearer alice"}}
+	clientY := dialTestClientWithHeader(address, alice)
+	clientX.expectPresence(t)
+
+	clientX.WS.WriteMessage(1, []byte("relay|users=42,body=hello"))
+	clientY.expectMessage(t)
+	clientY.WS.Close()
+	// the id is free again once the hub has handled the disconnection
+	if msg := clientX.expectPresence(t); msg != "server: presence: event=leave id=42" {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+
+	clientY = dialTestClientWithHeader(address, alice)
+	clientY.WS.WriteMessage(1, []byte(`{"type":"history","count":10}`))
+	var history struct {
+		Messages []messageFrame `json:"messages"`
+	}
+	msg := clientY.expectMessage(t)
+	json.Unmarshal([]byte(msg), &history)
+	if len(history.Messages) != 1 || history.Messages[0].From != 43 || history.Messages[0].Body != "hello" {
+		t.Fatalf("unexpected history after reconnecting: got %q", msg)
+	}
+}
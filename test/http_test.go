@@ -0,0 +1,87 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func postMessage(t *testing.T, address, token, payload string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "http://"+address+"/messages", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post message: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestPostMessage(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithHTTPToken("secret"))
+	clientX := newTestClient(address)
+
+	status, body := postMessage(t, address, "secret", fmt.Sprintf(`{"users":[%s,%s],"body":"hello world"}`, clientX.ID, unknownUserID))
+	if expected := fmt.Sprintf(`{"delivered":[%s],"failed":[%s]}`+"\n", clientX.ID, unknownUserID); status != http.StatusOK || body != expected {
+		t.Fatalf("unexpected response: expected 200 %q, got %d %q", expected, status, body)
+	}
+	if msg := clientX.expectMessage(t); msg != "server: #1 0-> hello world" {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+}
+
+func TestPostMessageUnauthorized(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithHTTPToken("secret"))
+	clientX := newTestClient(address)
+
+	status, _ := postMessage(t, address, "guess", fmt.Sprintf(`{"users":[%s],"body":"hello world"}`, clientX.ID))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected response: expected 401, got %d", status)
+	}
+	clientX.expectNoMessage(t)
+}
+
+func TestPostMessageSameUserTwice(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithHTTPToken("secret"))
+	clientX := newTestClient(address)
+
+	status, body := postMessage(t, address, "secret", fmt.Sprintf(`{"users":[%s,%s],"body":"hello world"}`, clientX.ID, clientX.ID))
+	if expected := fmt.Sprintf(`{"delivered":[%s],"failed":[]}`+"\n", clientX.ID); status != http.StatusOK || body != expected {
+		t.Fatalf("unexpected response: expected 200 %q, got %d %q", expected, status, body)
+	}
+	clientX.expectMessage(t)
+	clientX.expectNoMessage(t)
+}
+
+func TestPostMessageQueued(t *testing.T) {
+	address, _ := startOfflineHub(t, msgSystemHub.WithOfflineQueue(2), msgSystemHub.WithHTTPToken("secret"))
+
+	status, body := postMessage(t, address, "secret", `{"users":[42],"body":"hello world"}`)
+	if expected := `{"delivered":[],"failed":[],"queued":[42]}` + "\n"; status != http.StatusOK || body != expected {
+		t.Fatalf("unexpected response: expected 200 %q, got %d %q", expected, status, body)
+	}
+	clientY := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	if msg := clientY.expectMessage(t); msg != "server: #1 0-> hello world" {
+		t.Fatalf("unexpected queued message: got %q", msg)
+	}
+}
+
+func TestPostMessageClosedHub(t *testing.T) {
+	hub := msgSystemHub.NewHub("", msgSystemHub.WithHTTPToken("secret"))
+	server := httptest.NewServer(hub.Router())
+	defer server.Close()
+	hub.Start()
+	hub.Close()
+
+	status, _ := postMessage(t, strings.TrimPrefix(server.URL, "http://"), "secret", `{"users":[1],"body":"hello world"}`)
+	if status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected response: expected 503, got %d", status)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestInfo(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("info"))
+	msg := clientX.expectMessage(t)
+
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msg, "server: ")), &document); err != nil {
+		t.Fatalf("unexpected response from server: expected a JSON document, got %s, err: %v", msg, err)
+	}
+	for _, section := range []string{"server", "features", "limits", "client"} {
+		if _, found := document[section]; !found {
+			t.Fatalf("info document is missing the %s section: %s", section, msg)
+		}
+	}
+
+	var client struct {
+		ID int `json:"id"`
+	}
+	json.Unmarshal(document["client"], &client)
+	if fmt.Sprint(client.ID) != clientX.ID {
+		t.Fatalf("info document should describe client %s, got %s", clientX.ID, document["client"])
+	}
+}
+
+func TestInfoFeaturesAndClientLimits(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRateLimit(1, 10), msgSystemHub.WithOfflineQueue(5), msgSystemHub.WithSelfEcho(true),
+		msgSystemHub.WithMaxClients(10), msgSystemHub.WithIdleTimeout(time.Minute), msgSystemHub.WithByteQuota(100, 0),
+		msgSystemHub.WithHistoryCapacity(20), msgSystemHub.WithDebug(true), msgSystemHub.WithHTTPToken("secret"),
+		msgSystemHub.WithAllowEmptyBody(true), msgSystemHub.WithReconnectPolicy(msgSystemHub.EvictOld),
+		msgSystemHub.WithRelayErrorVerbosity(msgSystemHub.Verbose), msgSystemHub.WithUnknownCommandLimit(5, time.Minute))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	clientY.expectMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte("info"))
+	msg := clientX.expectMessage(t)
+	var document struct {
+		Features map[string]interface{} `json:"features"`
+		Limits   map[string]interface{} `json:"limits"`
+		Client   struct {
+			Limits map[string]interface{} `json:"limits"`
+		} `json:"client"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(msg, "server: ")), &document); err != nil {
+		t.Fatalf("unexpected response from server: expected a JSON document, got %s, err: %v", msg, err)
+	}
+
+	features := map[string]interface{}{"rate_limiting": true, "offline_queue": true, "self_echo": true, "compression": false, "tls": false,
+		"authentication": false, "history": true, "debug": true, "http_send": true, "allow_empty_body": true,
+		"reconnect_policy": "evict_old", "relay_error_verbosity": "verbose", "unknown_command_window_seconds": float64(60)}
+	for feature, value := range features {
+		if document.Features[feature] != value {
+			t.Fatalf("info document should report %s as %v: %s", feature, value, msg)
+		}
+	}
+	limits := map[string]float64{"max_clients": 10, "idle_timeout_seconds": 60, "rate_limit_per_second": 1, "rate_burst": 10, "byte_quota": 100,
+		"history_capacity": 20, "offline_queue_size": 5, "unknown_command_limit": 5}
+	for limit, value := range limits {
+		if document.Limits[limit] != value {
+			t.Fatalf("info document should report %s as %v: %s", limit, value, msg)
+		}
+	}
+	// the relay used 11 bytes of the client quota
+	if remaining := document.Client.Limits["byte_quota_remaining"]; remaining != float64(89) {
+		t.Fatalf("info document should report 89 bytes of quota left to the client, got %v: %s", remaining, msg)
+	}
+	// the id, relay and info commands took 3 messages of the burst
+	if remaining := document.Client.Limits["rate_limit_remaining"]; remaining != float64(7) {
+		t.Fatalf("info document should report 7 messages left to the client, got %v: %s", remaining, msg)
+	}
+}
+
+func TestJSONInfo(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	// the pipe-delimited command is answered the same way once the client uses JSON messages
+	for _, command := range []string{`{"type":"info"}`, "info"} {
+		clientX.WS.WriteMessage(1, []byte(command))
+		msg := clientX.expectMessage(t)
+		var document struct {
+			Type   string `json:"type"`
+			Client struct {
+				ID int `json:"id"`
+			} `json:"client"`
+		}
+		if err := json.Unmarshal([]byte(msg), &document); err != nil || document.Type != "info" || fmt.Sprint(document.Client.ID) != clientX.ID {
+			t.Fatalf("unexpected reply to %s: got %s", command, msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestInterceptorsPipeline(t *testing.T) {
+	upper := func(senderID int, body []byte) ([]byte, bool) {
+		return bytes.ToUpper(body), true
+	}
+	annotate := func(senderID int, body []byte) ([]byte, bool) {
+		return append(body, []byte(" [checked]")...), true
+	}
+	address := startHub(t, msgSystemHub.WithInterceptors(upper, annotate))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> HELLO WORLD [checked]", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+}
+
+func TestInterceptorDrop(t *testing.T) {
+	dropSpam := func(senderID int, body []byte) ([]byte, bool) {
+		return body, !bytes.Contains(body, []byte("spam"))
+	}
+	neverCalled := func(senderID int, body []byte) ([]byte, bool) {
+		t.Errorf("the pipeline should stop at the stage dropping the message")
+		return body, true
+	}
+	address := startHub(t, msgSystemHub.WithInterceptors(dropSpam, neverCalled))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=buy spam", clientY.ID)))
+	if msg := clientX.expectMessage(t); msg != "server: message dropped by the hub" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"broadcast","body":"buy spam"}`))
+	if msg := clientX.expectMessage(t); msg != `{"type":"error","code":"message_dropped","detail":"message dropped by the hub"}` {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+}
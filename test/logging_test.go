@@ -0,0 +1,93 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// capturingLog keeps the hub logs so that tests can inspect them
+type capturingLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *capturingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+// expectLine waits until the hub logged the given line
+func (l *capturingLog) expectLine(t *testing.T, line string) {
+	t.Helper()
+	deadline := time.Now().Add(responseTimeout)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		logged := l.buf.String()
+		l.mu.Unlock()
+		if strings.Contains(logged, line+"\n") {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("the hub did not log %q", line)
+}
+
+// capturingLogger keeps the lines the hub logs, prefixed with their level
+type capturingLogger struct {
+	capturingLog
+}
+
+func (l *capturingLogger) Debugf(format string, args ...interface{}) {
+	fmt.Fprintf(l, "debug: "+format+"\n", args...)
+}
+
+func (l *capturingLogger) Infof(format string, args ...interface{}) {
+	fmt.Fprintf(l, "info: "+format+"\n", args...)
+}
+
+func (l *capturingLogger) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(l, "error: "+format+"\n", args...)
+}
+
+func TestLogger(t *testing.T) {
+	logger := &capturingLogger{}
+	address := startHub(t, msgSystemHub.WithLogger(logger))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	logger.expectLine(t, "info: A new client "+clientX.ID+" connected with the hub from "+clientX.WS.LocalAddr().String())
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s,body=hello world", clientY.ID)))
+	if msg, expected := clientY.expectMessage(t), "server: #1 "+clientX.ID+"-> hello world"; msg != expected {
+		t.Fatalf("unexpected relayed message: expected %q, got %q", expected, msg)
+	}
+	logger.expectLine(t, "debug: from "+clientX.ID+": relay|users="+clientY.ID+",body=hello world")
+	logger.expectLine(t, "debug: Relayed message 1 from "+clientX.ID+" to 1 users")
+}
+
+func TestLogIdentity(t *testing.T) {
+	logs := &capturingLog{}
+	address := startHub(t, msgSystemHub.WithLogOutput(logs))
+	clientX := newTestClient(address)
+
+	logs.expectLine(t, "from "+clientX.ID+": id")
+	clientX.WS.Close()
+	logs.expectLine(t, "Client "+clientX.ID+" closed connection with the hub")
+}
+
+func TestLogIdentityRemoteAddr(t *testing.T) {
+	logs := &capturingLog{}
+	address := startHub(t, msgSystemHub.WithLogOutput(logs), msgSystemHub.WithLogIdentity(msgSystemHub.LogRemoteAddr))
+	clientX := newTestClient(address)
+	remoteAddr := clientX.WS.LocalAddr().String()
+
+	logs.expectLine(t, "from "+remoteAddr+": id")
+	clientX.WS.Close()
+	logs.expectLine(t, "Client "+remoteAddr+" closed connection with the hub")
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestSubscribeMetrics(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMetricsInterval(time.Millisecond*100))
+	clientX := newTestClient(address)
+	_ = newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("subscribe|metrics"))
+	if msg := clientX.expectMessage(t); msg != "server: subscribed to metrics" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+
+	msg := clientX.expectMessage(t)
+	if !strings.HasPrefix(msg, "server: metrics: clients=2 ") {
+		t.Fatalf("unexpected metrics frame: expected 2 connected clients, got %q", msg)
+	}
+}
+
+func TestSubscribeUnknownFeed(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("subscribe|weather"))
+	if msg := clientX.expectMessage(t); msg != "server: unknown subscription feed: weather" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestUnsubscribeMetrics(t *testing.T) {
+	for _, feed := range []string{"metrics", "all"} {
+		address := startHub(t, msgSystemHub.WithMetricsInterval(time.Millisecond*50))
+		clientX := newTestClient(address)
+
+		clientX.WS.WriteMessage(1, []byte("subscribe|metrics"))
+		clientX.expectMessage(t)
+		if msg := clientX.expectMessage(t); !strings.HasPrefix(msg, "server: metrics: ") {
+			t.Fatalf("unexpected metrics frame: got %q", msg)
+		}
+
+		clientX.WS.WriteMessage(1, []byte("unsubscribe|"+feed))
+		for msg := clientX.expectMessage(t); msg != "server: unsubscribed from "+feed; msg = clientX.expectMessage(t) {
+			if !strings.HasPrefix(msg, "server: metrics: ") {
+				t.Fatalf("unexpected response from server: got %q", msg)
+			}
+		}
+		clientX.expectNoMessage(t)
+	}
+}
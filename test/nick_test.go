@@ -0,0 +1,60 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNick(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("nick|name=alice"))
+	if msg := clientX.expectMessage(t); msg != "server: nickname set to alice" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+
+	clientY.WS.WriteMessage(1, []byte("list"))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: users list: \n0) %s (alice)\n", clientX.ID) {
+		t.Fatalf("unexpected users list: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=hello"))
+	if msg := clientY.expectMessage(t); msg != "server: #1 alice-> hello" {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+}
+
+func TestNickTaken(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("nick|name=alice"))
+	clientX.expectMessage(t)
+
+	clientY.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientY.expectMessage(t)
+	clientY.WS.WriteMessage(1, []byte("nick|name=alice"))
+	if msg := clientY.expectMessage(t); msg != `{"type":"error","code":"nick_taken","detail":"nickname alice is already taken"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+	clientY.WS.WriteMessage(1, []byte("nick|name="))
+	if msg := clientY.expectMessage(t); msg != `{"type":"error","code":"bad_request","detail":"nickname can't be empty"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+	// a numeric nickname would pass for the id of another user in relayed messages
+	clientY.WS.WriteMessage(1, []byte("nick|name="+clientX.ID))
+	if msg := clientY.expectMessage(t); msg != `{"type":"error","code":"bad_request","detail":"nickname can't be a number"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+
+	// the nickname is freed once its owner disconnects
+	clientX.WS.Close()
+	clientY.expectPresence(t)
+	clientY.WS.WriteMessage(1, []byte("nick|name=alice"))
+	if msg := clientY.expectMessage(t); msg != `{"type":"text","text":"nickname set to alice"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+}
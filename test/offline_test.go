@@ -0,0 +1,91 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// startOfflineHub starts a hub queueing messages for offline users, along with the authenticated
+// clientX (43) and clientY (42), and returns the address once clientY disconnected
+func startOfflineHub(t *testing.T, opts ...msgSystemHub.Option) (string, *TestClient) {
+	t.Helper()
+	opts = append(opts, msgSystemHub.WithAuthenticator(tokenAuthenticator{"alice": 42, "bob": 43}))
+	address := startHub(t, opts...)
+	clientX := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer bob"}})
+
+	clientY := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	if msg := clientX.expectPresence(t); msg != "server: presence: event=join id=42" {
+		t.Fatalf("unexpected join event: got %q", msg)
+	}
+	clientY.WS.Close()
+	if msg := clientX.expectPresence(t); msg != "server: presence: event=leave id=42" {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+	return address, clientX
+}
+
+func TestOfflineQueue(t *testing.T) {
+	address, clientX := startOfflineHub(t, msgSystemHub.WithOfflineQueue(2))
+
+	for i := 1; i <= 3; i++ {
+		clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=42,body=%d", i)))
+		if msg := clientX.expectMessage(t); msg != "server: user 42 is offline, message queued" {
+			t.Fatalf("unexpected response from server: got %q", msg)
+		}
+	}
+	clientX.WS.WriteMessage(1, []byte("relay|users="+unknownUserID+",body=hello"))
+	if msg := clientX.expectMessage(t); msg != "server: userid not found: "+unknownUserID {
+		t.Fatalf("users never seen should not get messages queued: got %q", msg)
+	}
+
+	// the oldest message was dropped, the others are delivered in order on reconnection
+	clientY := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	for _, body := range []string{"2", "3"} {
+		// the message ids match the bodies, 1 being the dropped message
+		if msg := clientY.expectMessage(t); msg != "server: #"+body+" 43-> "+body {
+			t.Fatalf("unexpected queued message: got %q", msg)
+		}
+	}
+	clientY.expectNoMessage(t)
+}
+
+func TestOfflineQueueTTL(t *testing.T) {
+	address, clientX := startOfflineHub(t, msgSystemHub.WithOfflineQueue(2), msgSystemHub.WithOfflineTTL(responseTimeout/10))
+
+	clientX.WS.WriteMessage(1, []byte("relay|users=42,body=hello"))
+	clientX.expectMessage(t)
+	time.Sleep(responseTimeout / 5)
+
+	clientY := dialTestClientWithHeader(address, http.Header{"Authorization": []string{"Bearer alice"}})
+	clientY.expectNoMessage(t)
+}
+
+func TestOfflineQueueDisabled(t *testing.T) {
+	_, clientX := startOfflineHub(t)
+
+	clientX.WS.WriteMessage(1, []byte("relay|users=42,body=hello"))
+	if msg := clientX.expectMessage(t); msg != "server: userid not found: 42" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestOfflineQueueSkipsAssignedIDs(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithOfflineQueue(2))
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientX.expectPresence(t) // join of clientY
+	clientY.WS.Close()
+	if msg := clientX.expectPresence(t); msg != "server: presence: event=leave id="+clientY.ID {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+
+	// ids assigned by the hub are never reused, so nothing is queued for them
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=hello"))
+	if msg := clientX.expectMessage(t); msg != "server: userid not found: "+clientY.ID {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
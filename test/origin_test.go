@@ -0,0 +1,55 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestAllowedOrigins(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAllowedOrigins([]string{"https://chat.example.com"}))
+	dialTestClient(address) // wait for the hub to start
+
+	if _, err := dialOrigin(address, "https://chat.example.com"); err != nil {
+		t.Fatalf("expected the allowed origin to connect: %v", err)
+	}
+	resp, err := dialOrigin(address, "https://evil.example.com")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the handshake to be rejected with 403, got %v", err)
+	}
+}
+
+func TestAllowedOriginsWildcard(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithAllowedOrigins([]string{"*"}))
+	dialTestClient(address)
+
+	if _, err := dialOrigin(address, "https://evil.example.com"); err != nil {
+		t.Fatalf("expected any origin to connect: %v", err)
+	}
+}
+
+func TestSameHostOrigin(t *testing.T) {
+	address := startHub(t)
+	dialTestClient(address)
+
+	if _, err := dialOrigin(address, "http://"+address); err != nil {
+		t.Fatalf("expected the hub host to connect: %v", err)
+	}
+	resp, err := dialOrigin(address, "https://evil.example.com")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the handshake to be rejected with 403, got %v", err)
+	}
+}
+
+// dialOrigin opens a websocket to the hub the way a browser page served from origin would
+func dialOrigin(address, origin string) (*http.Response, error) {
+	u := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+	conn, resp, err := websocket.DefaultDialer.Dial(u.String(), http.Header{"Origin": []string{origin}})
+	if err == nil {
+		conn.Close()
+	}
+	return resp, err
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	address := startHub(t)
+	clientA := newTestClient(address)
+
+	clientB := newTestClient(address)
+	if msg := clientA.expectPresence(t); msg != fmt.Sprintf("server: presence: event=join id=%s", clientB.ID) {
+		t.Fatalf("unexpected join event: got %q", msg)
+	}
+	clientB.WS.Close()
+	if msg := clientA.expectPresence(t); msg != fmt.Sprintf("server: presence: event=leave id=%s", clientB.ID) {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+	clientA.expectNoPresence(t)
+	clientA.expectNoMessage(t)
+}
+
+func TestJSONPresence(t *testing.T) {
+	address := startHub(t)
+	clientA := newTestClient(address)
+
+	clientA.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientA.expectMessage(t)
+
+	clientB := newTestClient(address)
+	if msg := clientA.expectPresence(t); msg != fmt.Sprintf(`{"type":"presence","event":"join","id":%s}`, clientB.ID) {
+		t.Fatalf("unexpected join event: got %q", msg)
+	}
+	clientB.WS.Close()
+	if msg := clientA.expectPresence(t); msg != fmt.Sprintf(`{"type":"presence","event":"leave","id":%s}`, clientB.ID) {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+}
+
+func TestUnsubscribePresence(t *testing.T) {
+	address := startHub(t)
+	clientA := newTestClient(address)
+
+	clientA.WS.WriteMessage(1, []byte("unsubscribe|presence"))
+	if msg := clientA.expectMessage(t); msg != "server: unsubscribed from presence" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	newTestClient(address)
+	clientA.expectNoPresence(t)
+
+	// subscribing again undoes unsubscribe
+	clientA.WS.WriteMessage(1, []byte("subscribe|presence"))
+	if msg := clientA.expectMessage(t); msg != "server: subscribed to presence" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientC := newTestClient(address)
+	if msg := clientA.expectPresence(t); msg != fmt.Sprintf("server: presence: event=join id=%s", clientC.ID) {
+		t.Fatalf("unexpected join event: got %q", msg)
+	}
+}
+
+// expectNoPresence fails the test if the client receives a presence event in the next moments
+func (c *TestClient) expectNoPresence(t *testing.T) {
+	t.Helper()
+	select {
+	case msg := <-c.Presence:
+		t.Fatalf("client %s received unexpected presence event: %s", c.ID, string(msg))
+	case <-time.After(responseTimeout / 4):
+	}
+}
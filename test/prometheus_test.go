@@ -0,0 +1,54 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// getMetrics scrapes the metrics endpoint of the hub
+func getMetrics(t *testing.T, address string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + address + "/metrics")
+	if err != nil {
+		t.Fatalf("get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf("relay|users=%s;%s,body=hello", clientY.ID, clientZ.ID)))
+	clientY.expectMessage(t)
+	clientZ.expectMessage(t)
+	clientX.WS.WriteMessage(1, []byte("relay|users="+unknownUserID+",body=hello"))
+	clientX.expectMessage(t)
+	clientX.WS.WriteMessage(1, []byte("relay|users=bob,body=hello"))
+	clientX.expectMessage(t)
+	clientZ.WS.Close()
+	clientX.expectPresence(t) // the join event of clientY
+	clientX.expectPresence(t) // the join event of clientZ
+	clientX.expectPresence(t) // the leave event
+
+	metrics := getMetrics(t, address)
+	for _, want := range []string{
+		"connected_clients 2\n",
+		"messages_received_total 6\n",
+		"messages_relayed_total 2\n",
+		"bytes_relayed_total 10\n",
+		`relay_errors_total{code="user_not_found"} 1` + "\n",
+		`relay_errors_total{code="bad_relay_format"} 1` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, metrics)
+		}
+	}
+}
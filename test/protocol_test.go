@@ -0,0 +1,232 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestJSONID(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf(`{"type":"id","id":%s}`, clientX.ID) {
+		t.Fatalf("unexpected id frame: got %q", msg)
+	}
+}
+
+func TestMalformedJSONKeepsProtocol(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+
+	// a message that doesn't decode doesn't switch the client to the JSON protocol
+	clientX.WS.WriteMessage(1, []byte(`{"type":"id"`))
+	if msg := clientX.expectMessage(t); msg != "server: malformed JSON message: unexpected end of JSON input" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+		t.Fatalf("unexpected id: got %q", msg)
+	}
+}
+
+func TestJSONList(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"list"}`))
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf(`{"type":"list","users":[%s]}`, clientY.ID) {
+		t.Fatalf("unexpected list frame: got %q", msg)
+	}
+}
+
+func TestJSONRelay(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	// clientZ switches to the JSON protocol, clientY keeps the pipe-delimited one
+	clientZ.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientZ.expectMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte(fmt.Sprintf(`{"type":"relay","users":[%s,%s],"body":"hi, body=x; bye"}`, clientY.ID, clientZ.ID)))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hi, body=x; bye", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+	frame := expectMessageFrame(t, clientZ)
+	if fmt.Sprint(frame.From) != clientX.ID || frame.ID != 1 || frame.Body != "hi, body=x; bye" {
+		t.Fatalf("unexpected message frame: got %+v", frame)
+	}
+}
+
+func TestJSONBroadcast(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"broadcast","body":"hello, chaps!"}`))
+	if msg := clientX.expectMessage(t); msg != `{"type":"text","text":"broadcast delivered to 1 clients"}` {
+		t.Fatalf("unexpected broadcast report: got %q", msg)
+	}
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello, chaps!", clientX.ID) {
+		t.Fatalf("unexpected broadcast message: got %q", msg)
+	}
+}
+
+func TestJSONMessageIDs(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientY.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientY.expectMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=first"))
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=second,dryrun=true"))
+	clientX.WS.WriteMessage(1, []byte("broadcast|body=third"))
+	clientX.WS.WriteMessage(1, []byte(`{"type":"relay","users":[`+clientY.ID+`],"body":"fourth"}`))
+
+	lastID := 0
+	for _, body := range []string{"first", "third", "fourth"} {
+		var frame struct {
+			ID   int    `json:"id"`
+			Body string `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(clientY.expectMessage(t)), &frame); err != nil {
+			t.Fatalf("unexpected message frame: %v", err)
+		}
+		if frame.Body != body || frame.ID <= lastID {
+			t.Fatalf("expected %s with an id above %d, got %+v", body, lastID, frame)
+		}
+		lastID = frame.ID
+	}
+}
+
+func TestJSONMessageEnvelope(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientY.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientY.expectMessage(t)
+
+	before := time.Now()
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=first"))
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=second"))
+	first, second := expectMessageFrame(t, clientY), expectMessageFrame(t, clientY)
+	if first.Seq <= 0 || second.Seq <= first.Seq {
+		t.Fatalf("expected increasing seq values, got %d then %d", first.Seq, second.Seq)
+	}
+	for _, frame := range []messageFrame{first, second} {
+		if ts := time.Unix(0, frame.TS*int64(time.Millisecond)); ts.Before(before.Truncate(time.Millisecond)) || ts.After(time.Now()) {
+			t.Fatalf("unexpected timestamp %d for %+v", frame.TS, frame)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithMaxBodySize(10), msgSystemHub.WithMaxReceivers(1))
+	clientX := newTestClient(address)
+	clientX.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientX.expectMessage(t)
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{`{"type":"relay","users":[],"body":"hello"}`, `{"type":"error","code":"bad_relay_format","detail":"relay message should contain users"}`},
+		{"relay|users=" + clientX.ID, `{"type":"error","code":"bad_relay_format","detail":"relay message should contain users and body fields"}`},
+		{`{"type":"relay","users":[` + unknownUserID + `],"body":"hello"}`, `{"type":"error","code":"user_not_found","detail":"userid not found: ` + unknownUserID + `"}`},
+		{`{"type":"relay","users":[1,2],"body":"hello"}`, `{"type":"error","code":"too_many_receivers","detail":"max receivers per message exceeded"}`},
+		{`{"type":"broadcast","body":"hello chaps"}`, `{"type":"error","code":"body_too_large","detail":"message body can't exceed 10 bytes"}`},
+		{`{"type":"shout"}`, `{"type":"error","code":"unknown_command","detail":"unknown message type: shout"}`},
+		{"shout", `{"type":"error","code":"unknown_command","detail":"command not recognized"}`},
+		{`{"type":"list"`, `{"type":"error","code":"bad_request","detail":"malformed JSON message: unexpected end of JSON input"}`},
+	}
+	for _, tt := range tests {
+		clientX.WS.WriteMessage(1, []byte(tt.message))
+		if msg := clientX.expectMessage(t); msg != tt.want {
+			t.Fatalf("unexpected reply to %s: got %q, want %q", tt.message, msg, tt.want)
+		}
+	}
+}
+
+func TestJSONRateLimited(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRateLimit(1, 2))
+	clientX := newTestClient(address)
+
+	for i := 0; i < 2; i++ {
+		clientX.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	}
+	clientX.expectMessage(t)
+	if msg := clientX.expectMessage(t); msg != `{"type":"error","code":"rate_limited","detail":"rate limit exceeded"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+}
+
+func TestJSONClosing(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRateLimit(1, 2))
+	clientX := newTestClient(address)
+	clientX.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	clientX.expectMessage(t)
+
+	for i := 0; i < 10; i++ {
+		clientX.WS.WriteMessage(1, []byte(`{"type":"id"}`))
+	}
+	clientX.expectClosing(t, `{"type":"closing","reason":"rate_limited","reconnect":true}`, websocket.ClosePolicyViolation)
+}
+
+func TestJSONRooms(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"join","room":"foo"}`))
+	if msg := clientX.expectMessage(t); msg != `{"type":"text","text":"joined room foo"}` {
+		t.Fatalf("unexpected join reply: got %q", msg)
+	}
+	clientY.WS.WriteMessage(1, []byte(`{"type":"join","room":"foo"}`))
+	clientY.expectMessage(t)
+
+	clientX.WS.WriteMessage(1, []byte(`{"type":"send","room":"foo","body":"hi, body=x; bye"}`))
+	clientX.expectMessage(t)
+	frame := expectMessageFrame(t, clientY)
+	if fmt.Sprint(frame.From) != clientX.ID || frame.Room != "foo" || frame.Body != "hi, body=x; bye" {
+		t.Fatalf("unexpected message frame: got %+v", frame)
+	}
+
+	clientY.WS.WriteMessage(1, []byte(`{"type":"leave","room":"foo"}`))
+	if msg := clientY.expectMessage(t); msg != `{"type":"text","text":"left room foo"}` {
+		t.Fatalf("unexpected leave reply: got %q", msg)
+	}
+	clientY.WS.WriteMessage(1, []byte(`{"type":"join","room":""}`))
+	if msg := clientY.expectMessage(t); msg != `{"type":"error","code":"bad_request","detail":"room name can't be empty"}` {
+		t.Fatalf("unexpected join reply: got %q", msg)
+	}
+}
+
+// messageFrame is a message delivered to a JSON client
+type messageFrame struct {
+	ID   int    `json:"id"`
+	From int    `json:"from"`
+	Seq  int    `json:"seq"`
+	TS   int64  `json:"ts"`
+	Room string `json:"room"`
+	Body string `json:"body"`
+}
+
+// expectMessageFrame waits for the next message frame sent to the client
+func expectMessageFrame(t *testing.T, c *TestClient) messageFrame {
+	t.Helper()
+	var frame messageFrame
+	msg := c.expectMessage(t)
+	if err := json.Unmarshal([]byte(msg), &frame); err != nil {
+		t.Fatalf("unexpected message frame %q: %v", msg, err)
+	}
+	return frame
+}
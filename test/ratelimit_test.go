@@ -0,0 +1,43 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestRateLimit(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRateLimit(1, 3))
+	clientX := newTestClient(address) // the id round-trip takes the first token
+	clientY := newTestClient(address)
+
+	for i := 0; i < 5; i++ {
+		clientX.WS.WriteMessage(1, []byte("id"))
+	}
+	for i := 0; i < 2; i++ {
+		if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+			t.Fatalf("message within the burst should be handled: got %q", msg)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if msg := clientX.expectMessage(t); msg != "server: rate limit exceeded" {
+			t.Fatalf("message over the rate should be refused: got %q", msg)
+		}
+	}
+
+	clientY.WS.WriteMessage(1, []byte("id"))
+	if msg := clientY.expectMessage(t); msg != "server: "+clientY.ID {
+		t.Fatalf("a slow client should not be rate limited: got %q", msg)
+	}
+}
+
+func TestRateLimitDisconnect(t *testing.T) {
+	address := startHub(t, msgSystemHub.WithRateLimit(1, 1))
+	clientX := newTestClient(address)
+
+	for i := 0; i < 10; i++ {
+		clientX.WS.WriteMessage(1, []byte("id"))
+	}
+	clientX.expectClosing(t, "server: closing: reason=rate_limited reconnect=true", websocket.ClosePolicyViolation)
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReply(t *testing.T) {
+	address := startHub(t)
+	clientA := newTestClient(address)
+	clientB := newTestClient(address)
+
+	clientA.WS.WriteMessage(1, []byte("relay|users="+clientB.ID+",body=hello"))
+	clientB.expectMessage(t)
+
+	clientB.WS.WriteMessage(1, []byte("reply|body=hello back"))
+	if msg := clientA.expectMessage(t); msg != fmt.Sprintf("server: #2 %s-> hello back", clientB.ID) {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+}
+
+func TestReplyErrors(t *testing.T) {
+	address := startHub(t)
+	clientA := newTestClient(address)
+	clientB := newTestClient(address)
+
+	clientB.WS.WriteMessage(1, []byte(`{"type":"reply","body":"anyone?"}`))
+	if msg := clientB.expectMessage(t); msg != `{"type":"error","code":"no_reply_target","detail":"no message to reply to"}` {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+
+	clientA.WS.WriteMessage(1, []byte("relay|users="+clientB.ID+",body=hello"))
+	clientB.expectMessage(t)
+	clientA.WS.Close()
+	clientB.expectPresence(t) // the leave event
+
+	clientB.WS.WriteMessage(1, []byte(`{"type":"reply","body":"hello back"}`))
+	if msg := clientB.expectMessage(t); msg != fmt.Sprintf(`{"type":"error","code":"user_not_found","detail":"user %s is offline"}`, clientA.ID) {
+		t.Fatalf("unexpected reply: got %q", msg)
+	}
+}
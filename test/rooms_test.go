@@ -0,0 +1,70 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRooms(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+	clientZ := newTestClient(address)
+
+	for _, c := range []*TestClient{clientX, clientY} {
+		c.WS.WriteMessage(1, []byte("join|room=foo"))
+		if msg := c.expectMessage(t); msg != "server: joined room foo" {
+			t.Fatalf("unexpected response from server: got %q", msg)
+		}
+	}
+
+	clientX.WS.WriteMessage(1, []byte("send|room=foo,body=hello, chaps!"))
+	if msg := clientX.expectMessage(t); msg != "server: room message delivered to 1 clients" {
+		t.Fatalf("unexpected send report: got %q", msg)
+	}
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 [foo] %s-> hello, chaps!", clientX.ID) {
+		t.Fatalf("unexpected room message: got %q", msg)
+	}
+	clientZ.expectNoMessage(t)
+
+	clientZ.WS.WriteMessage(1, []byte("send|room=foo,body=let me in"))
+	if msg := clientZ.expectMessage(t); msg != "server: not a member of room foo" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+
+	clientY.WS.WriteMessage(1, []byte("leave|room=foo"))
+	if msg := clientY.expectMessage(t); msg != "server: left room foo" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+	clientX.WS.WriteMessage(1, []byte("send|room=foo,body=anyone?"))
+	if msg := clientX.expectMessage(t); msg != "server: room message delivered to 0 clients" {
+		t.Fatalf("unexpected send report: got %q", msg)
+	}
+	clientY.expectNoMessage(t)
+
+	clientY.WS.WriteMessage(1, []byte("leave|room=foo"))
+	if msg := clientY.expectMessage(t); msg != "server: not a member of room foo" {
+		t.Fatalf("unexpected response from server: got %q", msg)
+	}
+}
+
+func TestRoomsLeftOnDisconnect(t *testing.T) {
+	address := startHub(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	for _, c := range []*TestClient{clientX, clientY} {
+		c.WS.WriteMessage(1, []byte("join|room=foo"))
+		c.expectMessage(t)
+	}
+
+	clientX.expectPresence(t) // the join event of clientY
+	clientY.WS.Close()
+	if msg := clientX.expectPresence(t); msg != fmt.Sprintf("server: presence: event=leave id=%s", clientY.ID) {
+		t.Fatalf("unexpected leave event: got %q", msg)
+	}
+	clientX.WS.WriteMessage(1, []byte("send|room=foo,body=anyone?"))
+	if msg := clientX.expectMessage(t); msg != "server: room message delivered to 0 clients" {
+		t.Fatalf("unexpected send report: got %q", msg)
+	}
+}
@@ -0,0 +1,41 @@
+package test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+// startTestServer serves a hub with httptest and returns its address, the hub is closed when the test ends
+func startTestServer(t *testing.T, opts ...msgSystemHub.Option) string {
+	hub := msgSystemHub.NewHub("", opts...)
+	server := httptest.NewServer(hub.Router())
+	hub.Start()
+	t.Cleanup(server.Close)
+	t.Cleanup(hub.Close) // runs first, hijacked websocket connections aren't closed by the server
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestRouter(t *testing.T) {
+	address := startTestServer(t)
+	clientX := newTestClient(address)
+	clientY := newTestClient(address)
+
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); msg != "server: "+clientX.ID {
+		t.Fatalf("unexpected id: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("list"))
+	if msg := clientX.expectMessage(t); msg != fmt.Sprintf("server: users list: \n0) %s\n", clientY.ID) {
+		t.Fatalf("unexpected users list: got %q", msg)
+	}
+
+	clientX.WS.WriteMessage(1, []byte("relay|users="+clientY.ID+",body=hello world"))
+	if msg := clientY.expectMessage(t); msg != fmt.Sprintf("server: #1 %s-> hello world", clientX.ID) {
+		t.Fatalf("unexpected relayed message: got %q", msg)
+	}
+}
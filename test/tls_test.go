@@ -0,0 +1,97 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgSystemHub "github.com/jpaldi/golang-simplified-message-system/server"
+)
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, roots := selfSignedCertificate(t)
+	address := startHub(t, msgSystemHub.WithTLS(certFile, keyFile))
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	u := url.URL{Scheme: "wss", Host: address, Path: "/ws"}
+	var conn *websocket.Conn
+	var err error
+	for retries := 0; retries < 50; retries++ { // the hub may still be starting up
+		conn, _, err = dialer.Dial(u.String(), nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatalf("could not dial the hub over TLS: %v", err)
+	}
+	clientX := startTestClient(conn)
+
+	clientX.WS.WriteMessage(1, []byte("id"))
+	if msg := clientX.expectMessage(t); !strings.HasPrefix(msg, "server: ") {
+		t.Fatalf("unexpected id response: got %q", msg)
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/ws", nil); err == nil {
+		t.Fatal("expected the hub to refuse plain websocket connections")
+	}
+}
+
+// selfSignedCertificate writes a certificate valid for 127.0.0.1 and its key, and returns the pool trusting it
+func selfSignedCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hub"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not encode key: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "hub-tls")
+	if err != nil {
+		t.Fatalf("could not create certificate directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("could not write certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, roots
+}